@@ -97,6 +97,10 @@ echo "---> Creating monitoring namespace and priority class"
 kubectl apply -f /var/lib/gravity/resources/namespace.yaml
 kubectl apply -f /var/lib/gravity/resources/priority-class.yaml
 
+# Create the configmap with Grafana teams and service accounts managed by the watcher
+kubectl --namespace monitoring get configmap grafana-access || \
+    kubectl --namespace monitoring create configmap grafana-access
+
 # Generate password for Grafana administrator
 password=$(tr -dc 'a-zA-Z0-9' < /dev/urandom | fold -w 32 | head -n 1 | tr -d '\n ' | /opt/bin/base64)
 
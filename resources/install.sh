@@ -6,6 +6,10 @@ do
     /opt/bin/kubectl apply -f /var/lib/gravity/resources/${name}.yaml
 done
 
+# Create the configmap with Grafana teams and service accounts managed by the watcher
+/opt/bin/kubectl --namespace monitoring get configmap grafana-access || \
+    /opt/bin/kubectl --namespace monitoring create configmap grafana-access
+
 # Generate password for Grafana administrator
 password=$(tr -dc 'a-zA-Z0-9' < /dev/urandom | fold -w 32 | head -n 1 | tr -d '\n ' | /opt/bin/base64)
 
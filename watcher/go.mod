@@ -3,9 +3,10 @@ module github.com/gravitational/monitoring-app/watcher
 go 1.15
 
 require (
-	github.com/cenkalti/backoff v2.1.1+incompatible
+	github.com/cenkalti/backoff v2.2.1+incompatible
 	github.com/ghodss/yaml v1.0.0
-	github.com/gosimple/slug v1.1.1
+	github.com/go-kit/log v0.1.0
+	github.com/google/go-jsonnet v0.17.0
 	github.com/gravitational/rigging v0.0.0-20210825034630-62c449aedb8d
 	github.com/gravitational/roundtrip v1.0.0
 	github.com/gravitational/trace v1.1.6
@@ -13,8 +14,9 @@ require (
 	github.com/kylelemons/godebug v1.1.0 // indirect
 	github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring v0.50.0
 	github.com/prometheus-operator/prometheus-operator/pkg/client v0.50.0
-	github.com/prometheus/common v0.10.0
-	github.com/rainycape/unidecode v0.0.0-20150907023854-cb7f23ec59be // indirect
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.29.0
+	github.com/prometheus/prometheus v1.8.2-0.20210701133801-b0944590a1c9
 	github.com/sirupsen/logrus v1.6.0
 	gopkg.in/yaml.v2 v2.4.0
 	k8s.io/api v0.22.0
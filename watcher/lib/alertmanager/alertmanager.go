@@ -0,0 +1,308 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alertmanager
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+
+	"github.com/gravitational/roundtrip"
+	"github.com/gravitational/trace"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// Client is the Alertmanager HTTP API client
+type Client struct {
+	*roundtrip.Client
+}
+
+// NewClient returns an Alertmanager HTTP API client for the specified address.
+// If the address is empty, it is taken from the environment or defaults
+// to the in-cluster Alertmanager service.
+func NewClient(apiAddress string) (*Client, error) {
+	if apiAddress == "" {
+		apiAddress = os.Getenv(constants.AlertmanagerAPIAddrEnv)
+	}
+	if apiAddress == "" {
+		apiAddress = constants.AlertmanagerAPIAddress
+	}
+
+	client, err := roundtrip.NewClient(apiAddress, "")
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	return &Client{Client: client}, nil
+}
+
+// Health checks the status of Alertmanager HTTP API
+func (c *Client) Health(ctx context.Context) error {
+	response, err := c.Get(ctx, c.Endpoint("-", "healthy"), url.Values{})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(checkResponse(response))
+}
+
+// Alert is an alert posted to Alertmanager
+type Alert struct {
+	// Labels is the alert identifying labels
+	Labels map[string]string `json:"labels"`
+	// Annotations is the alert annotations
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// StartsAt is the time the alert started firing
+	StartsAt *time.Time `json:"startsAt,omitempty"`
+	// EndsAt is the time the alert is resolved at
+	EndsAt *time.Time `json:"endsAt,omitempty"`
+	// GeneratorURL identifies the alert source
+	GeneratorURL string `json:"generatorURL,omitempty"`
+}
+
+// PostAlerts creates or updates the provided alerts
+func (c *Client) PostAlerts(ctx context.Context, alerts ...Alert) error {
+	response, err := c.PostJSON(ctx, c.Endpoint("api", "v2", "alerts"), alerts)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(checkResponse(response))
+}
+
+// Notifications describes the number of notifications Alertmanager has
+// attempted to send
+type Notifications struct {
+	// Total is the total number of notification attempts
+	Total float64
+	// Failed is the number of failed notification attempts
+	Failed float64
+}
+
+// Sent returns the number of successfully sent notifications
+func (n Notifications) Sent() float64 {
+	return n.Total - n.Failed
+}
+
+// GetNotifications returns notification counters of the specified integrations
+// from Alertmanager metrics. If the counters are labeled with the receiver name,
+// only the counters of the specified receiver are included.
+func (c *Client) GetNotifications(ctx context.Context, receiver string, integrations []string) (*Notifications, error) {
+	response, err := c.Get(ctx, c.Endpoint("metrics"), url.Values{})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := checkResponse(response); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(bytes.NewReader(response.Bytes()))
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	filter := notificationsFilter{receiver: receiver, integrations: integrations}
+	return &Notifications{
+		Total:  filter.sum(families[notificationsTotalMetric]),
+		Failed: filter.sum(families[notificationsFailedMetric]),
+	}, nil
+}
+
+// TestAlertLabels returns the labels of a new synthetic test alert with the
+// provided additional labels. The test id label is unique for each test alert.
+func TestAlertLabels(labels map[string]string) map[string]string {
+	result := map[string]string{
+		"alertname":      constants.TestAlertName,
+		"severity":       "info",
+		TestAlertIDLabel: time.Now().UTC().Format("20060102150405"),
+	}
+	for k, v := range labels {
+		result[k] = v
+	}
+	return result
+}
+
+// TestAlertConfig is the synthetic test alert configuration
+type TestAlertConfig struct {
+	// Labels is the test alert labels
+	Labels map[string]string
+	// Receivers is the receivers the test alert is routed to
+	Receivers []TestAlertReceiver
+	// Timeout is the maximum amount of time to wait for the notifications
+	Timeout time.Duration
+}
+
+// TestAlertReceiver is a receiver the test alert is routed to
+type TestAlertReceiver struct {
+	// Name is the receiver name
+	Name string
+	// Integrations is the integrations of the receiver, such as email or webhook
+	Integrations []string
+}
+
+// SendTestAlert posts a synthetic alert, waits until Alertmanager reports
+// that a notification has been sent by each of the receivers the alert is
+// routed to and then resolves the alert.
+func (c *Client) SendTestAlert(ctx context.Context, config TestAlertConfig) error {
+	if config.Timeout == 0 {
+		config.Timeout = defaultTestAlertTimeout
+	}
+	if len(config.Receivers) == 0 {
+		return trace.BadParameter("the test alert is not routed to any receiver with notification integrations")
+	}
+	before := make([]*Notifications, len(config.Receivers))
+	for i, receiver := range config.Receivers {
+		notifications, err := c.GetNotifications(ctx, receiver.Name, receiver.Integrations)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		before[i] = notifications
+	}
+
+	alert := Alert{
+		Labels: config.Labels,
+		Annotations: map[string]string{
+			"summary":     "Test alert",
+			"description": "This is a synthetic alert sent to verify alert notifications delivery.",
+		},
+		StartsAt: timeP(time.Now().UTC()),
+	}
+	log.Infof("Sending test alert %v.", config.Labels)
+	if err := c.PostAlerts(ctx, alert); err != nil {
+		return trace.Wrap(err)
+	}
+	defer func() {
+		alert.EndsAt = timeP(time.Now().UTC())
+		// The alert is resolved even if the test has been canceled.
+		ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+		defer cancel()
+		if err := c.PostAlerts(ctx, alert); err != nil {
+			log.WithError(err).Warn("Failed to resolve test alert.")
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
+	defer cancel()
+	sent := make(map[string]bool, len(config.Receivers))
+	for {
+		select {
+		case <-time.After(constants.PollInterval):
+			for i, receiver := range config.Receivers {
+				if sent[receiver.Name] {
+					continue
+				}
+				after, err := c.GetNotifications(ctx, receiver.Name, receiver.Integrations)
+				if err != nil {
+					log.WithError(err).Debug("Failed to query notifications.")
+					continue
+				}
+				if after.Failed > before[i].Failed {
+					return trace.BadParameter("Alertmanager failed to send test alert notification via receiver %q, "+
+						"check Alertmanager logs", receiver.Name)
+				}
+				if after.Sent() > before[i].Sent() {
+					log.Infof("Test alert notification has been sent via receiver %q.", receiver.Name)
+					sent[receiver.Name] = true
+				}
+			}
+			if len(sent) == len(config.Receivers) {
+				return nil
+			}
+		case <-ctx.Done():
+			return trace.LimitExceeded("no notification has been sent within %v", config.Timeout)
+		}
+	}
+}
+
+func timeP(t time.Time) *time.Time {
+	return &t
+}
+
+// checkResponse returns an error if the response has non-2xx status code
+func checkResponse(response *roundtrip.Response) error {
+	if response.Code() < http.StatusOK || response.Code() >= http.StatusMultipleChoices {
+		return trace.ReadError(response.Code(), response.Bytes())
+	}
+	return nil
+}
+
+// notificationsFilter selects the notification counters of a receiver
+type notificationsFilter struct {
+	// receiver is the receiver name
+	receiver string
+	// integrations is the integrations of the receiver
+	integrations []string
+}
+
+// sum returns the sum of the counter values in the metric family that match the filter
+func (f notificationsFilter) sum(family *dto.MetricFamily) (sum float64) {
+	if family == nil {
+		return 0
+	}
+	for _, metric := range family.GetMetric() {
+		if f.matches(metric) {
+			sum += metric.GetCounter().GetValue()
+		}
+	}
+	return sum
+}
+
+// matches returns true if the metric has one of the filter integrations and,
+// if it is labeled with the receiver name, the filter receiver
+func (f notificationsFilter) matches(metric *dto.Metric) bool {
+	var integration, receiver string
+	for _, label := range metric.GetLabel() {
+		switch label.GetName() {
+		case integrationLabel:
+			integration = label.GetValue()
+		case receiverNameLabel:
+			receiver = label.GetValue()
+		}
+	}
+	if receiver != "" && receiver != f.receiver {
+		return false
+	}
+	for _, i := range f.integrations {
+		if i == integration {
+			return true
+		}
+	}
+	return false
+}
+
+const (
+	// TestAlertIDLabel is the test alert label with the unique test id
+	TestAlertIDLabel = "test_id"
+	// notificationsTotalMetric is the metric with the total number of notification attempts
+	notificationsTotalMetric = "alertmanager_notifications_total"
+	// notificationsFailedMetric is the metric with the number of failed notification attempts
+	notificationsFailedMetric = "alertmanager_notifications_failed_total"
+	// integrationLabel is the notification metrics label with the integration name
+	integrationLabel = "integration"
+	// receiverNameLabel is the notification metrics label with the receiver name.
+	// It is only set if Alertmanager has the receiver-name-in-metrics feature enabled.
+	receiverNameLabel = "receiver_name"
+	// resolveTimeout is the maximum amount of time to spend resolving the test alert
+	resolveTimeout = 30 * time.Second
+	// defaultTestAlertTimeout is the default time to wait for a test alert notification.
+	// It should exceed the route's group_wait.
+	defaultTestAlertTimeout = 5 * time.Minute
+)
@@ -0,0 +1,234 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package alertpacks provides the sets of alerts shipped with the watcher.
+//
+// Packs can be enabled or disabled as a whole or have individual alerts
+// disabled or their thresholds and for durations overridden. Packs replacing
+// default rules the monitoring chart no longer installs are enabled by
+// default, other packs have to be enabled.
+package alertpacks
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/resources"
+
+	"github.com/gravitational/trace"
+)
+
+// Pack is a set of built-in alerts enabled or disabled as a whole.
+type Pack struct {
+	// Name is the pack name.
+	Name string
+	// Description describes the alerts in the pack.
+	Description string
+	// EnabledByDefault is whether the pack is enabled without configuration.
+	EnabledByDefault bool
+	// Groups is the list of alert groups in the pack.
+	Groups []Group
+}
+
+// Group is a group of built-in alerts evaluated together.
+type Group struct {
+	// Name is the group name.
+	Name string
+	// Alerts is the list of alerts in the group.
+	Alerts []Alert
+}
+
+// Alert is a built-in alerting rule with a configurable threshold.
+type Alert struct {
+	// Name is the alert name.
+	Name string
+	// Expr is the alert expression template. The {{.Threshold}}
+	// placeholder is replaced with the alert threshold.
+	Expr string
+	// Threshold is the default alert threshold, only used if the expression
+	// has the threshold placeholder.
+	Threshold float64
+	// For is the default duration the expression has to hold before the alert fires.
+	For time.Duration
+	// Labels is the labels that get attached to the alert.
+	Labels map[string]string
+	// Annotations are used to attach longer information to the alert.
+	Annotations map[string]string
+}
+
+// Config configures a pack.
+type Config struct {
+	// Enabled optionally overrides whether the pack is enabled by default.
+	Enabled *bool
+	// Alerts configures individual alerts by alert name.
+	Alerts map[string]AlertConfig
+}
+
+// AlertConfig configures a built-in alert.
+type AlertConfig struct {
+	// Disabled is whether the alert is disabled.
+	Disabled bool
+	// Threshold optionally overrides the default alert threshold.
+	Threshold *float64
+	// For optionally overrides the default alert for duration.
+	For *time.Duration
+}
+
+// All returns all built-in packs.
+func All() []Pack {
+	return packs
+}
+
+// Get returns the built-in pack with the specified name.
+func Get(name string) (*Pack, error) {
+	for _, pack := range packs {
+		if pack.Name == name {
+			return &pack, nil
+		}
+	}
+	return nil, trace.NotFound("unknown alert pack %q, available packs: %v", name, Names())
+}
+
+// Names returns the sorted list of names of built-in packs.
+func Names() (names []string) {
+	for _, pack := range packs {
+		names = append(names, pack.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CRDName returns the name of PrometheusRule custom resource with the
+// alerts of the specified pack.
+func CRDName(pack string) string {
+	return fmt.Sprintf("alert-pack-%v", pack)
+}
+
+// CheckConfig makes sure the config only refers to alerts in the pack and
+// only overrides thresholds of alerts that have one.
+//
+// Alerts with the same name, e.g. with different severities, are configured together.
+func (p Pack) CheckConfig(config Config) error {
+	// thresholds maps names of alerts in the pack to whether they have a threshold
+	thresholds := make(map[string]bool)
+	for _, group := range p.Groups {
+		for _, alert := range group.Alerts {
+			thresholds[alert.Name] = thresholds[alert.Name] || alert.hasThreshold()
+		}
+	}
+	var unknown, fixed []string
+	for name, alertConfig := range config.Alerts {
+		hasThreshold, ok := thresholds[name]
+		if !ok {
+			unknown = append(unknown, name)
+		} else if alertConfig.Threshold != nil && !hasThreshold {
+			fixed = append(fixed, name)
+		}
+	}
+	if len(unknown) != 0 {
+		sort.Strings(unknown)
+		return trace.BadParameter("alert pack %v has no alerts %q", p.Name, unknown)
+	}
+	if len(fixed) != 0 {
+		sort.Strings(fixed)
+		return trace.BadParameter("alerts %q of alert pack %v have no threshold to override", fixed, p.Name)
+	}
+	return nil
+}
+
+// Enabled returns true if the pack is enabled with the provided config.
+func (p Pack) Enabled(config Config) bool {
+	if config.Enabled != nil {
+		return *config.Enabled
+	}
+	return p.EnabledByDefault
+}
+
+// Alert returns the pack as a monitoring alert configured with the provided
+// config or nil if the pack or all its alerts are disabled.
+func (p Pack) Alert(config Config) (*resources.Alert, error) {
+	if err := p.CheckConfig(config); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if !p.Enabled(config) {
+		return nil, nil
+	}
+	alert := &resources.Alert{CRDName: CRDName(p.Name)}
+	for _, group := range p.Groups {
+		ruleGroup := resources.RuleGroup{Name: group.Name}
+		for _, packAlert := range group.Alerts {
+			alertConfig := config.Alerts[packAlert.Name]
+			if alertConfig.Disabled {
+				continue
+			}
+			rule, err := packAlert.rule(alertConfig)
+			if err != nil {
+				return nil, trace.Wrap(err)
+			}
+			ruleGroup.Rules = append(ruleGroup.Rules, *rule)
+		}
+		if len(ruleGroup.Rules) != 0 {
+			alert.Groups = append(alert.Groups, ruleGroup)
+		}
+	}
+	if len(alert.Groups) == 0 {
+		return nil, nil
+	}
+	if err := alert.Check(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return alert, nil
+}
+
+// hasThreshold returns true if the alert expression has the threshold placeholder.
+func (a Alert) hasThreshold() bool {
+	return strings.Contains(a.Expr, "{{.Threshold}}")
+}
+
+// rule returns the alerting rule configured with the provided config.
+func (a Alert) rule(config AlertConfig) (*resources.Rule, error) {
+	threshold := a.Threshold
+	if config.Threshold != nil {
+		threshold = *config.Threshold
+	}
+	forDuration := a.For
+	if config.For != nil {
+		forDuration = *config.For
+	}
+	tmpl, err := template.New(a.Name).Option("missingkey=error").Parse(a.Expr)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var expr bytes.Buffer
+	err = tmpl.Execute(&expr, map[string]string{
+		"Threshold": strconv.FormatFloat(threshold, 'f', -1, 64),
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &resources.Rule{
+		Alert:       a.Name,
+		Expr:        expr.String(),
+		For:         forDuration,
+		Labels:      a.Labels,
+		Annotations: a.Annotations,
+	}, nil
+}
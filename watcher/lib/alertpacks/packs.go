@@ -0,0 +1,238 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package alertpacks
+
+import "time"
+
+// packs is the list of built-in packs.
+//
+// The kubernetes-resources and kubernetes-storage packs replace the default
+// rule groups of the same names that the monitoring chart does not install
+// (see defaultRules in custom-values.yaml), so they are enabled by default.
+// They keep the alerts of kube-prometheus-stack 17.2.2 as they are. The other
+// packs add alerts clusters did not have before, so they are disabled by default.
+var packs = []Pack{
+	{
+		Name:        "nethealth",
+		Description: "Network connectivity between cluster nodes as measured by nethealth.",
+		Groups: []Group{{
+			Name: "nethealth",
+			Alerts: []Alert{
+				{
+					Name: "NethealthPacketLoss",
+					Expr: `sum by (node_name, peer_name) (rate(nethealth_echo_timeout_total[1m]))
+/
+sum by (node_name, peer_name) (rate(nethealth_echo_request_total[1m])) > {{.Threshold}}`,
+					Threshold: 0.2,
+					For:       5 * time.Minute,
+					Labels:    map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary":     "Packet loss between cluster nodes.",
+						"description": "{{ $value | humanizePercentage }} of packets from node {{ $labels.node_name }} to {{ $labels.peer_name }} are lost.",
+					},
+				},
+				{
+					Name:      "NethealthHighLatency",
+					Expr:      `max by (node_name, peer_name) (nethealth_echo_latency_summary_milli{quantile="0.9"}) > {{.Threshold}}`,
+					Threshold: 100,
+					For:       5 * time.Minute,
+					Labels:    map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"summary":     "High network latency between cluster nodes.",
+						"description": "90th percentile latency from node {{ $labels.node_name }} to {{ $labels.peer_name }} is {{ $value }}ms.",
+					},
+				},
+			},
+		}},
+	},
+	{
+		Name:             "kubernetes-resources",
+		Description:      "Cluster and namespace resource quotas and overcommitment.",
+		EnabledByDefault: true,
+		Groups: []Group{{
+			Name: "kubernetes-resources",
+			Alerts: []Alert{
+				{
+					Name: "KubeCPUOvercommit",
+					Expr: `sum(namespace_cpu:kube_pod_container_resource_requests:sum{})
+  /
+sum(kube_node_status_allocatable{resource="cpu"})
+  >
+((count(kube_node_status_allocatable{resource="cpu"}) > 1) - 1) / count(kube_node_status_allocatable{resource="cpu"})`,
+					For:    5 * time.Minute,
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"description": "Cluster has overcommitted CPU resource requests for Pods and cannot tolerate node failure.",
+						"runbook_url": runbookURL + "alert-name-kubecpuovercommit",
+						"summary":     "Cluster has overcommitted CPU resource requests.",
+					},
+				},
+				{
+					Name: "KubeMemoryOvercommit",
+					Expr: `sum(namespace_memory:kube_pod_container_resource_requests:sum{})
+  /
+sum(kube_node_status_allocatable{resource="memory"})
+  >
+((count(kube_node_status_allocatable{resource="memory"}) > 1) - 1)
+  /
+count(kube_node_status_allocatable{resource="memory"})`,
+					For:    5 * time.Minute,
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"description": "Cluster has overcommitted memory resource requests for Pods and cannot tolerate node failure.",
+						"runbook_url": runbookURL + "alert-name-kubememoryovercommit",
+						"summary":     "Cluster has overcommitted memory resource requests.",
+					},
+				},
+				{
+					Name: "KubeCPUQuotaOvercommit",
+					Expr: `sum(kube_resourcequota{job="kube-state-metrics", type="hard", resource="cpu"})
+  /
+sum(kube_node_status_allocatable{resource="cpu"})
+  > {{.Threshold}}`,
+					Threshold: 1.5,
+					For:       5 * time.Minute,
+					Labels:    map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"description": "Cluster has overcommitted CPU resource requests for Namespaces.",
+						"runbook_url": runbookURL + "alert-name-kubecpuquotaovercommit",
+						"summary":     "Cluster has overcommitted CPU resource requests.",
+					},
+				},
+				{
+					Name: "KubeMemoryQuotaOvercommit",
+					Expr: `sum(kube_resourcequota{job="kube-state-metrics", type="hard", resource="memory"})
+  /
+sum(kube_node_status_allocatable{resource="memory",job="kube-state-metrics"})
+  > {{.Threshold}}`,
+					Threshold: 1.5,
+					For:       5 * time.Minute,
+					Labels:    map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"description": "Cluster has overcommitted memory resource requests for Namespaces.",
+						"runbook_url": runbookURL + "alert-name-kubememoryquotaovercommit",
+						"summary":     "Cluster has overcommitted memory resource requests.",
+					},
+				},
+				{
+					Name: "KubeQuotaAlmostFull",
+					Expr: `kube_resourcequota{job="kube-state-metrics", type="used"}
+  / ignoring(instance, job, type)
+(kube_resourcequota{job="kube-state-metrics", type="hard"} > 0)
+  > {{.Threshold}} < 1`,
+					Threshold: 0.9,
+					For:       15 * time.Minute,
+					Labels:    map[string]string{"severity": "info"},
+					Annotations: map[string]string{
+						"description": "Namespace {{ $labels.namespace }} is using {{ $value | humanizePercentage }} of its {{ $labels.resource }} quota.",
+						"runbook_url": runbookURL + "alert-name-kubequotaalmostfull",
+						"summary":     "Namespace quota is going to be full.",
+					},
+				},
+				{
+					Name: "KubeQuotaFullyUsed",
+					Expr: `kube_resourcequota{job="kube-state-metrics", type="used"}
+  / ignoring(instance, job, type)
+(kube_resourcequota{job="kube-state-metrics", type="hard"} > 0)
+  == 1`,
+					For:    15 * time.Minute,
+					Labels: map[string]string{"severity": "info"},
+					Annotations: map[string]string{
+						"description": "Namespace {{ $labels.namespace }} is using {{ $value | humanizePercentage }} of its {{ $labels.resource }} quota.",
+						"runbook_url": runbookURL + "alert-name-kubequotafullyused",
+						"summary":     "Namespace quota is fully used.",
+					},
+				},
+				{
+					Name: "KubeQuotaExceeded",
+					Expr: `kube_resourcequota{job="kube-state-metrics", type="used"}
+  / ignoring(instance, job, type)
+(kube_resourcequota{job="kube-state-metrics", type="hard"} > 0)
+  > {{.Threshold}}`,
+					Threshold: 1,
+					For:       15 * time.Minute,
+					Labels:    map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"description": "Namespace {{ $labels.namespace }} is using {{ $value | humanizePercentage }} of its {{ $labels.resource }} quota.",
+						"runbook_url": runbookURL + "alert-name-kubequotaexceeded",
+						"summary":     "Namespace quota has exceeded the limits.",
+					},
+				},
+			},
+		}},
+	},
+	{
+		Name:             "kubernetes-storage",
+		Description:      "Usage and errors of persistent volumes.",
+		EnabledByDefault: true,
+		Groups: []Group{{
+			Name: "kubernetes-storage",
+			Alerts: []Alert{
+				{
+					Name: "KubePersistentVolumeFillingUp",
+					Expr: `(
+  kubelet_volume_stats_available_bytes{job="kubelet", metrics_path="/metrics"}
+    /
+  kubelet_volume_stats_capacity_bytes{job="kubelet", metrics_path="/metrics"}
+) < 0.03
+and
+kubelet_volume_stats_used_bytes{job="kubelet", metrics_path="/metrics"} > 0`,
+					For:    time.Minute,
+					Labels: map[string]string{"severity": "critical"},
+					Annotations: map[string]string{
+						"description": "The PersistentVolume claimed by {{ $labels.persistentvolumeclaim }} in Namespace {{ $labels.namespace }} is only {{ $value | humanizePercentage }} free.",
+						"runbook_url": runbookURL + "alert-name-kubepersistentvolumefillingup",
+						"summary":     "PersistentVolume is filling up.",
+					},
+				},
+				{
+					Name: "KubePersistentVolumeFillingUp",
+					Expr: `(
+  kubelet_volume_stats_available_bytes{job="kubelet", metrics_path="/metrics"}
+    /
+  kubelet_volume_stats_capacity_bytes{job="kubelet", metrics_path="/metrics"}
+) < 0.15
+and
+kubelet_volume_stats_used_bytes{job="kubelet", metrics_path="/metrics"} > 0
+and
+predict_linear(kubelet_volume_stats_available_bytes{job="kubelet", metrics_path="/metrics"}[6h], 4 * 24 * 3600) < 0`,
+					For:    time.Hour,
+					Labels: map[string]string{"severity": "warning"},
+					Annotations: map[string]string{
+						"description": "Based on recent sampling, the PersistentVolume claimed by {{ $labels.persistentvolumeclaim }} in Namespace {{ $labels.namespace }} is expected to fill up within four days. Currently {{ $value | humanizePercentage }} is available.",
+						"runbook_url": runbookURL + "alert-name-kubepersistentvolumefillingup",
+						"summary":     "PersistentVolume is filling up.",
+					},
+				},
+				{
+					Name:   "KubePersistentVolumeErrors",
+					Expr:   `kube_persistentvolume_status_phase{phase=~"Failed|Pending",job="kube-state-metrics"} > 0`,
+					For:    5 * time.Minute,
+					Labels: map[string]string{"severity": "critical"},
+					Annotations: map[string]string{
+						"description": "The persistent volume {{ $labels.persistentvolume }} has status {{ $labels.phase }}.",
+						"runbook_url": runbookURL + "alert-name-kubepersistentvolumeerrors",
+						"summary":     "PersistentVolume is having issues with provisioning.",
+					},
+				},
+			},
+		}},
+	},
+}
+
+// runbookURL is the prefix of runbook URLs of the kube-prometheus alerts.
+const runbookURL = "https://github.com/kubernetes-monitoring/kubernetes-mixin/tree/master/runbook.md#"
@@ -45,6 +45,39 @@ const (
 	// GrafanaPasswordEnv is the name of environment variable with Grafana password
 	GrafanaPasswordEnv = "GRAFANA_PASSWORD"
 
+	// GrafanaUsernameFileEnv is the name of environment variable with the path of the file with Grafana username
+	GrafanaUsernameFileEnv = "GRAFANA_USERNAME_FILE"
+
+	// GrafanaPasswordFileEnv is the name of environment variable with the path of the file with Grafana password
+	GrafanaPasswordFileEnv = "GRAFANA_PASSWORD_FILE"
+
+	// GrafanaTokenEnv is the name of environment variable with Grafana API key or service account token
+	GrafanaTokenEnv = "GRAFANA_TOKEN"
+
+	// GrafanaTokenFileEnv is the name of environment variable with the path of the file with
+	// Grafana API key or service account token
+	GrafanaTokenFileEnv = "GRAFANA_TOKEN_FILE"
+
+	// GrafanaCAFileEnv is the name of environment variable with the path of the CA certificate
+	// used to verify Grafana server certificate
+	GrafanaCAFileEnv = "GRAFANA_CA_FILE"
+
+	// GrafanaCertFileEnv is the name of environment variable with the path of the client certificate
+	GrafanaCertFileEnv = "GRAFANA_CERT_FILE"
+
+	// GrafanaKeyFileEnv is the name of environment variable with the path of the client certificate key
+	GrafanaKeyFileEnv = "GRAFANA_KEY_FILE"
+
+	// GrafanaInsecureSkipVerifyEnv is the name of environment variable that disables
+	// verification of Grafana server certificate if set to true
+	GrafanaInsecureSkipVerifyEnv = "GRAFANA_INSECURE_SKIP_VERIFY"
+
+	// AlertmanagerAPIAddress is the API address of the in-cluster Alertmanager service
+	AlertmanagerAPIAddress = "http://monitoring-kube-prometheus-alertmanager.monitoring.svc.cluster.local:9093"
+
+	// AlertmanagerAPIAddrEnv is the name of environment variable with Alertmanager API address
+	AlertmanagerAPIAddrEnv = "ALERTMANAGER_API_ADDRESS"
+
 	// DashboardPrefix is the prefix of configmaps with dashboards data
 	DashboardPrefix = "dashboard-"
 
@@ -55,10 +88,28 @@ const (
 	MonitoringLabel = "monitoring"
 	// MonitoringUpdateAlert defines the update for an alert
 	MonitoringUpdateAlert = "alert"
+	// MonitoringUpdateRecordingRule defines the update for recording rules
+	MonitoringUpdateRecordingRule = "recording-rule"
 	// MonitoringUpdateAlertTarget defines the update for an alert target
 	MonitoringUpdateAlertTarget = "alert-target"
 	// MonitoringUpdateDashboard defines the update for a dashboard
 	MonitoringUpdateDashboard = "dashboard"
+	// MonitoringUpdateAlertPack defines the update for a built-in alert pack configuration
+	MonitoringUpdateAlertPack = "alert-pack"
+	// MonitoringUpdateSLO defines the update for a service level objective
+	MonitoringUpdateSLO = "slo"
+	// MonitoringUpdateDatasource defines the update for a Grafana datasource
+	MonitoringUpdateDatasource = "datasource"
+	// MonitoringUpdateGrafanaNotifier defines the update for a Grafana notification channel or contact point
+	MonitoringUpdateGrafanaNotifier = "grafana-notifier"
+	// MonitoringUpdateGrafanaTeam defines the update for a Grafana team
+	MonitoringUpdateGrafanaTeam = "grafana-team"
+	// MonitoringUpdateGrafanaServiceAccount defines the update for a Grafana service account
+	MonitoringUpdateGrafanaServiceAccount = "grafana-service-account"
+	// MonitoringUpdateGrafanaPermissions defines the update for Grafana folder or dashboard permissions
+	MonitoringUpdateGrafanaPermissions = "grafana-permissions"
+	// MonitoringUpdateJsonnetLibrary defines the update for Jsonnet libraries imported by dashboards
+	MonitoringUpdateJsonnetLibrary = "jsonnet-library"
 	// MonitoringUpdateSMTP defines the update for kapacitor SMTP configuration
 	MonitoringUpdateSMTP = "smtp"
 
@@ -71,6 +122,67 @@ const (
 	// SMTPSecret specifies the name of the SMTP configuration secret
 	SMTPSecret = "smtp-configuration"
 
+	// TestAlertName specifies the name of the synthetic test alert
+	TestAlertName = "WatcherTestAlert"
+
+	// TestAlertReceiver specifies the name of the Alertmanager receiver older
+	// versions routed the synthetic test alert to. It is removed from the
+	// Alertmanager configuration so the test alert takes the regular routes
+	TestAlertReceiver = "watcher-test-alert"
+
+	// TestAlertAnnotation is the annotation on alert target resources that
+	// requests a synthetic test alert to be sent after the target is updated.
+	// The watcher removes the annotation once it sends the test alert
+	TestAlertAnnotation = "monitoring.gravitational.io/test-alert"
+
+	// SMTPTestMessageAnnotation is the annotation on the SMTP configuration
+	// secret with the hash of the spec the SMTP test message was sent for
+	SMTPTestMessageAnnotation = "monitoring.gravitational.io/smtp-test-message"
+
+	// DashboardsAnnotation is the annotation on dashboard ConfigMaps with the
+	// JSON list of uids of the dashboards created from the ConfigMap
+	DashboardsAnnotation = "monitoring.gravitational.io/dashboards"
+
+	// DashboardFolderAnnotation is the annotation on dashboard ConfigMaps with
+	// the title of the Grafana folder for the dashboards
+	DashboardFolderAnnotation = "monitoring.gravitational.io/folder"
+
+	// DashboardInputsAnnotation is the annotation on dashboard ConfigMaps with
+	// the JSON object with the values of the dashboard inputs by input name
+	DashboardInputsAnnotation = "monitoring.gravitational.io/inputs"
+
+	// DashboardInputClusterName is the name of the dashboard input set to the cluster name
+	DashboardInputClusterName = "CLUSTER_NAME"
+
+	// DashboardInputNamespace is the name of the dashboard input set to the namespace of the dashboard ConfigMap
+	DashboardInputNamespace = "NAMESPACE"
+
+	// ManagedByLabel is the label that marks resources created by the watcher
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+	// ManagedByWatcher is the value of ManagedByLabel on resources created by the watcher
+	ManagedByWatcher = "monitoring-watcher"
+
+	// SLOLabel is the label with the SLO name on series and alerts generated for SLOs
+	SLOLabel = "slo"
+
+	// DashboardOriginField is the dashboard JSON field that references the
+	// ConfigMap the dashboard has been created from
+	DashboardOriginField = "monitoringOrigin"
+	// DatasourceOriginField is the datasource JSON data field that references
+	// the resource the datasource has been created from
+	DatasourceOriginField = "monitoringOrigin"
+	// NotifierSecureSettingsHashField is the contact point settings field
+	// with the hash of the secure settings set by the watcher
+	NotifierSecureSettingsHashField = "monitoringSecureSettingsHash"
+
+	// GarbageCollectionInterval is the interval between removals of resources
+	// whose source ConfigMaps no longer exist
+	GarbageCollectionInterval = 10 * time.Minute
+
+	// GrafanaAccessConfigMap specifies the name of the configmap with the
+	// names of Grafana teams and service accounts created by the watcher
+	GrafanaAccessConfigMap = "grafana-access"
+
 	// AlertTargetConfigMap specifies the name of the alert target configmap
 	AlertTargetConfigMap = "alerting-addresses"
 
@@ -93,6 +205,15 @@ const (
 	PrometheusName = "monitoring-kube-prometheus-prometheus"
 )
 
+const (
+	// CommandTestAlert is the subcommand that sends a synthetic test alert
+	CommandTestAlert = "test-alert"
+	// CommandTestRules is the subcommand that runs offline alert rule unit tests
+	CommandTestRules = "test-rules"
+	// CommandLint is the subcommand that checks alerts against the lint policy
+	CommandLint = "lint"
+)
+
 var (
 	// AllModes contains names of all modes the watcher can run in
 	AllModes = []string{
@@ -0,0 +1,584 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+)
+
+// Team is a Grafana team
+type Team struct {
+	// Name is the team name
+	Name string
+	// Email is the optional team email
+	Email string
+	// Members is the list of logins or emails of team members
+	Members []string
+}
+
+// ServiceAccount is a Grafana service account
+type ServiceAccount struct {
+	// Name is the service account name
+	Name string
+	// Role is the organization role of the service account, e.g. Viewer
+	Role string
+	// Disabled is whether the service account is disabled
+	Disabled bool
+}
+
+// Permissions is the permissions of a folder or a dashboard
+type Permissions struct {
+	// Folder is the title of the folder, created if needed
+	Folder string
+	// DashboardUID is the uid of the dashboard
+	DashboardUID string
+	// Items is the list of permissions, they replace the existing ones
+	Items []Permission
+}
+
+// Permission grants a permission to a team, a user or an organization role
+type Permission struct {
+	// Team is the name of the team granted the permission
+	Team string
+	// User is the login or email of the user granted the permission
+	User string
+	// Role is the organization role granted the permission, Viewer or Editor
+	Role string
+	// Permission is the permission level, View, Edit or Admin
+	Permission string
+}
+
+// UpsertTeam creates a new or updates the existing team with the same name
+// and makes its members match the provided ones. It returns true if the team
+// has been created.
+//
+// Members have to exist in Grafana, e.g. they logged in once with an external
+// authentication provider.
+func (c *Client) UpsertTeam(ctx context.Context, team Team) (created bool, err error) {
+	existing, err := c.getTeam(ctx, team.Name)
+	if err != nil && !trace.IsNotFound(err) {
+		return false, trace.Wrap(err)
+	}
+	var id int64
+	if existing == nil {
+		log.Infof("Creating team %q.", team.Name)
+		response, err := c.PostJSON(ctx, c.Endpoint("api", "teams"), teamRequest{Name: team.Name, Email: team.Email})
+		if err != nil {
+			return false, trace.Wrap(err)
+		}
+		if err := checkResponse(response); err != nil {
+			return false, trace.Wrap(err)
+		}
+		var result struct {
+			// TeamID is the id of the created team
+			TeamID int64 `json:"teamId"`
+		}
+		if err := json.Unmarshal(response.Bytes(), &result); err != nil {
+			return true, trace.Wrap(err)
+		}
+		id = result.TeamID
+		created = true
+	} else {
+		id = existing.ID
+		if existing.Email != team.Email {
+			log.Infof("Updating team %q.", team.Name)
+			response, err := c.PutJSON(ctx, c.Endpoint("api", "teams", fmt.Sprint(id)), teamRequest{Name: team.Name, Email: team.Email})
+			if err != nil {
+				return false, trace.Wrap(err)
+			}
+			if err := checkResponse(response); err != nil {
+				return false, trace.Wrap(err)
+			}
+		}
+	}
+	return created, trace.Wrap(c.syncTeamMembers(ctx, id, team))
+}
+
+// DeleteTeam deletes the team with the specified name
+func (c *Client) DeleteTeam(ctx context.Context, name string) error {
+	team, err := c.getTeam(ctx, name)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	log.Infof("Deleting team %q.", name)
+	response, err := c.Delete(ctx, c.Endpoint("api", "teams", fmt.Sprint(team.ID)))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(checkResponse(response))
+}
+
+// syncTeamMembers adds the members of the team missing from the team with the
+// specified id and removes the ones no longer listed.
+func (c *Client) syncTeamMembers(ctx context.Context, id int64, team Team) error {
+	response, err := c.Get(ctx, c.Endpoint("api", "teams", fmt.Sprint(id), "members"), url.Values{})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := checkResponse(response); err != nil {
+		return trace.Wrap(err)
+	}
+	var members []teamMember
+	if err := json.Unmarshal(response.Bytes(), &members); err != nil {
+		return trace.Wrap(err)
+	}
+	current := make(map[int64]struct{}, len(members))
+	for _, member := range members {
+		current[member.UserID] = struct{}{}
+	}
+
+	var errors []error
+	wanted := make(map[int64]struct{}, len(team.Members))
+	for _, login := range team.Members {
+		userID, err := c.getUserID(ctx, login)
+		if err != nil {
+			errors = append(errors, trace.Wrap(err, "team %q member %q", team.Name, login))
+			continue
+		}
+		wanted[userID] = struct{}{}
+		if _, ok := current[userID]; ok {
+			continue
+		}
+		log.Infof("Adding %q to team %q.", login, team.Name)
+		response, err := c.PostJSON(ctx, c.Endpoint("api", "teams", fmt.Sprint(id), "members"), teamMemberRequest{UserID: userID})
+		if err == nil {
+			err = checkResponse(response)
+		}
+		if err != nil {
+			errors = append(errors, trace.Wrap(err, "team %q member %q", team.Name, login))
+		}
+	}
+	if len(errors) != 0 {
+		// Members are not removed unless all listed members are known so a
+		// typo does not lock out the existing members.
+		return trace.NewAggregate(errors...)
+	}
+	for _, member := range members {
+		if _, ok := wanted[member.UserID]; ok {
+			continue
+		}
+		log.Infof("Removing %q from team %q.", member.Login, team.Name)
+		response, err := c.Delete(ctx, c.Endpoint("api", "teams", fmt.Sprint(id), "members", fmt.Sprint(member.UserID)))
+		if err == nil {
+			err = checkResponse(response)
+		}
+		if err != nil && !trace.IsNotFound(err) {
+			errors = append(errors, trace.Wrap(err, "team %q member %q", team.Name, member.Login))
+		}
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// getTeam returns the team with the specified name
+func (c *Client) getTeam(ctx context.Context, name string) (*teamInfo, error) {
+	response, err := c.Get(ctx, c.Endpoint("api", "teams", "search"), url.Values{
+		"name": []string{name},
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := checkResponse(response); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var result struct {
+		// Teams is the list of found teams
+		Teams []teamInfo `json:"teams"`
+	}
+	if err := json.Unmarshal(response.Bytes(), &result); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	for _, team := range result.Teams {
+		if team.Name == name {
+			return &team, nil
+		}
+	}
+	return nil, trace.NotFound("team %q not found", name)
+}
+
+// getUserID returns the id of the user with the specified login or email
+func (c *Client) getUserID(ctx context.Context, loginOrEmail string) (int64, error) {
+	response, err := c.Get(ctx, c.Endpoint("api", "users", "lookup"), url.Values{
+		"loginOrEmail": []string{loginOrEmail},
+	})
+	if err != nil {
+		return 0, trace.Wrap(err)
+	}
+	if err := checkResponse(response); err != nil {
+		if trace.IsNotFound(err) {
+			return 0, trace.NotFound("user %q not found", loginOrEmail)
+		}
+		return 0, trace.Wrap(err)
+	}
+	var user struct {
+		// ID is the user id
+		ID int64 `json:"id"`
+	}
+	if err := json.Unmarshal(response.Bytes(), &user); err != nil {
+		return 0, trace.Wrap(err)
+	}
+	return user.ID, nil
+}
+
+// UpsertServiceAccount creates a new or updates the existing service account with the same name.
+// It returns true if the service account has been created.
+//
+// Service accounts are supported by Grafana 8.5 and newer.
+func (c *Client) UpsertServiceAccount(ctx context.Context, account ServiceAccount) (created bool, err error) {
+	existing, err := c.getServiceAccount(ctx, account.Name)
+	if err != nil && !trace.IsNotFound(err) {
+		return false, trace.Wrap(err)
+	}
+	request := serviceAccountRequest{
+		Name:       account.Name,
+		Role:       account.Role,
+		IsDisabled: account.Disabled,
+	}
+	if existing == nil {
+		log.Infof("Creating service account %q.", account.Name)
+		response, err := c.PostJSON(ctx, c.Endpoint("api", "serviceaccounts"), request)
+		if err != nil {
+			return false, trace.Wrap(err)
+		}
+		if err := checkResponse(response); err != nil {
+			return false, trace.Wrap(err)
+		}
+		return true, nil
+	}
+	if existing.Role == account.Role && existing.IsDisabled == account.Disabled {
+		return false, nil
+	}
+	log.Infof("Updating service account %q.", account.Name)
+	response, err := c.PatchJSON(ctx, c.Endpoint("api", "serviceaccounts", fmt.Sprint(existing.ID)), request)
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	return false, trace.Wrap(checkResponse(response))
+}
+
+// DeleteServiceAccount deletes the service account with the specified name
+func (c *Client) DeleteServiceAccount(ctx context.Context, name string) error {
+	account, err := c.getServiceAccount(ctx, name)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	log.Infof("Deleting service account %q.", name)
+	response, err := c.Delete(ctx, c.Endpoint("api", "serviceaccounts", fmt.Sprint(account.ID)))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(checkResponse(response))
+}
+
+// getServiceAccount returns the service account with the specified name
+func (c *Client) getServiceAccount(ctx context.Context, name string) (*serviceAccountInfo, error) {
+	response, err := c.Get(ctx, c.Endpoint("api", "serviceaccounts", "search"), url.Values{
+		"query": []string{name},
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := checkResponse(response); err != nil {
+		if trace.IsNotFound(err) {
+			return nil, trace.BadParameter("service accounts are not supported by Grafana, version 8.5 or newer is required")
+		}
+		return nil, trace.Wrap(err)
+	}
+	var result struct {
+		// ServiceAccounts is the list of found service accounts
+		ServiceAccounts []serviceAccountInfo `json:"serviceAccounts"`
+	}
+	if err := json.Unmarshal(response.Bytes(), &result); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	for _, account := range result.ServiceAccounts {
+		if account.Name == name {
+			return &account, nil
+		}
+	}
+	return nil, trace.NotFound("service account %q not found", name)
+}
+
+// SetPermissions replaces the permissions of the folder or the dashboard with the provided ones.
+func (c *Client) SetPermissions(ctx context.Context, permissions Permissions) error {
+	var request permissionsRequest
+	for _, permission := range permissions.Items {
+		item, err := c.newPermissionItem(ctx, permission)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		request.Items = append(request.Items, *item)
+	}
+	endpoint, err := c.permissionsEndpoint(ctx, permissions, len(permissions.Items) != 0)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	existing, err := c.getPermissions(ctx, endpoint)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if permissionsEqual(existing, request.Items) {
+		return nil
+	}
+	log.Infof("Setting permissions of %v.", permissions)
+	response, err := c.PostJSON(ctx, endpoint, request)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(checkResponse(response))
+}
+
+// ResetPermissions restores the default permissions of the folder or the dashboard.
+func (c *Client) ResetPermissions(ctx context.Context, permissions Permissions) error {
+	endpoint, err := c.permissionsEndpoint(ctx, permissions, false)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	log.Infof("Resetting permissions of %v.", permissions)
+	response, err := c.PostJSON(ctx, endpoint, permissionsRequest{Items: []permissionItem{
+		{Role: "Viewer", Permission: permissionLevels["View"]},
+		{Role: "Editor", Permission: permissionLevels["Edit"]},
+	}})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(checkResponse(response))
+}
+
+// String returns the description of the permissions target
+func (p Permissions) String() string {
+	if p.Folder != "" {
+		return fmt.Sprintf("folder %q", p.Folder)
+	}
+	return fmt.Sprintf("dashboard %v", p.DashboardUID)
+}
+
+// permissionsEndpoint returns the endpoint that sets the permissions of the folder or the dashboard.
+// The folder is created if it does not exist and create is set.
+func (c *Client) permissionsEndpoint(ctx context.Context, permissions Permissions, create bool) (string, error) {
+	if permissions.Folder != "" && create {
+		folder, err := c.EnsureFolder(ctx, permissions.Folder)
+		if err != nil {
+			return "", trace.Wrap(err)
+		}
+		return c.Endpoint("api", "folders", folder.UID, "permissions"), nil
+	}
+	if permissions.Folder != "" {
+		folders, err := c.getFolders(ctx)
+		if err != nil {
+			return "", trace.Wrap(err)
+		}
+		for _, folder := range folders {
+			if folder.Title == permissions.Folder {
+				return c.Endpoint("api", "folders", folder.UID, "permissions"), nil
+			}
+		}
+		return "", trace.NotFound("folder %q not found", permissions.Folder)
+	}
+	dashboard, err := c.getDashboard(ctx, permissions.DashboardUID)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	var id int64
+	if err := json.Unmarshal(dashboard["id"], &id); err != nil {
+		return "", trace.Wrap(err)
+	}
+	return c.Endpoint("api", "dashboards", "id", fmt.Sprint(id), "permissions"), nil
+}
+
+// newPermissionItem returns the API representation of the permission.
+// The permission has to be granted to exactly one of a team, a user or a role.
+func (c *Client) newPermissionItem(ctx context.Context, permission Permission) (*permissionItem, error) {
+	level, ok := permissionLevels[permission.Permission]
+	if !ok {
+		return nil, trace.BadParameter("unknown permission %q", permission.Permission)
+	}
+	grantees := 0
+	for _, grantee := range []string{permission.Team, permission.User, permission.Role} {
+		if grantee != "" {
+			grantees++
+		}
+	}
+	if grantees != 1 {
+		return nil, trace.BadParameter("permission has to be granted to exactly one of a team, a user or a role")
+	}
+	item := &permissionItem{Permission: level}
+	switch {
+	case permission.Role != "":
+		item.Role = permission.Role
+	case permission.Team != "":
+		team, err := c.getTeam(ctx, permission.Team)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		item.TeamID = team.ID
+	case permission.User != "":
+		userID, err := c.getUserID(ctx, permission.User)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		item.UserID = userID
+	}
+	return item, nil
+}
+
+// hasCustomPermissions returns true if permissions of the folder with the
+// specified uid are granted to teams or users
+func (c *Client) hasCustomPermissions(ctx context.Context, uid string) (bool, error) {
+	items, err := c.getPermissions(ctx, c.Endpoint("api", "folders", uid, "permissions"))
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	for _, item := range items {
+		if item.TeamID != 0 || item.UserID != 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// getPermissions returns the permissions set on the folder or the dashboard
+// with the provided permissions endpoint. Permissions a dashboard inherits
+// from its folder are not included.
+func (c *Client) getPermissions(ctx context.Context, endpoint string) ([]permissionItem, error) {
+	response, err := c.Get(ctx, endpoint, url.Values{})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := checkResponse(response); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var items []permissionItem
+	if err := json.Unmarshal(response.Bytes(), &items); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var permissions []permissionItem
+	for _, item := range items {
+		if !item.Inherited {
+			permissions = append(permissions, item)
+		}
+	}
+	return permissions, nil
+}
+
+// permissionsEqual returns true if the provided permission lists grant the
+// same permissions regardless of their order
+func permissionsEqual(a, b []permissionItem) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[permissionItem]int)
+	for _, item := range a {
+		counts[item]++
+	}
+	for _, item := range b {
+		if counts[item] == 0 {
+			return false
+		}
+		counts[item]--
+	}
+	return true
+}
+
+// teamInfo is a team returned by search
+type teamInfo struct {
+	// ID is the team id
+	ID int64 `json:"id"`
+	// Name is the team name
+	Name string `json:"name"`
+	// Email is the team email
+	Email string `json:"email"`
+}
+
+// teamRequest is request to create or update a team
+type teamRequest struct {
+	// Name is the team name
+	Name string `json:"name"`
+	// Email is the team email
+	Email string `json:"email,omitempty"`
+}
+
+// teamMember is a member of a team
+type teamMember struct {
+	// UserID is the user id
+	UserID int64 `json:"userId"`
+	// Login is the user login
+	Login string `json:"login"`
+}
+
+// teamMemberRequest is request to add a team member
+type teamMemberRequest struct {
+	// UserID is the user id
+	UserID int64 `json:"userId"`
+}
+
+// serviceAccountInfo is a service account returned by search
+type serviceAccountInfo struct {
+	// ID is the service account id
+	ID int64 `json:"id"`
+	// Name is the service account name
+	Name string `json:"name"`
+	// Role is the organization role of the service account
+	Role string `json:"role"`
+	// IsDisabled is whether the service account is disabled
+	IsDisabled bool `json:"isDisabled"`
+}
+
+// serviceAccountRequest is request to create or update a service account
+type serviceAccountRequest struct {
+	// Name is the service account name
+	Name string `json:"name"`
+	// Role is the organization role of the service account
+	Role string `json:"role,omitempty"`
+	// IsDisabled is whether the service account is disabled
+	IsDisabled bool `json:"isDisabled"`
+}
+
+// permissionsRequest is request to replace folder or dashboard permissions
+type permissionsRequest struct {
+	// Items is the list of permissions
+	Items []permissionItem `json:"items"`
+}
+
+// permissionItem is a folder or dashboard permission
+type permissionItem struct {
+	// Role is the organization role granted the permission
+	Role string `json:"role,omitempty"`
+	// TeamID is the id of the team granted the permission
+	TeamID int64 `json:"teamId,omitempty"`
+	// UserID is the id of the user granted the permission
+	UserID int64 `json:"userId,omitempty"`
+	// Permission is the permission level
+	Permission int `json:"permission"`
+	// Inherited is whether the dashboard permission is inherited from its folder
+	Inherited bool `json:"inherited,omitempty"`
+}
+
+// permissionLevels maps permission names to Grafana permission levels
+var permissionLevels = map[string]int{
+	"View":  1,
+	"Edit":  2,
+	"Admin": 4,
+}
+
+// PermissionNames is the list of supported permission levels
+var PermissionNames = []string{"View", "Edit", "Admin"}
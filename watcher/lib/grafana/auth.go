@@ -0,0 +1,244 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grafana
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+
+	"github.com/gravitational/trace"
+)
+
+// credential is a credential set either directly or by the path of the file with it
+type credential struct {
+	// value is the credential value
+	value string
+	// path is the path of the file with the credential value
+	path string
+}
+
+// credentialFromEnv returns the credential from the specified environment variables,
+// the file takes precedence over the value.
+func credentialFromEnv(valueEnv, pathEnv string) credential {
+	return credential{
+		value: os.Getenv(valueEnv),
+		path:  os.Getenv(pathEnv),
+	}
+}
+
+// isSet returns true if the credential is set.
+func (c credential) isSet() bool {
+	return c.value != "" || c.path != ""
+}
+
+// get returns the credential value.
+//
+// The file is read on every call so the updates of mounted Secrets are picked up.
+func (c credential) get() (string, error) {
+	if c.path == "" {
+		return c.value, nil
+	}
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return "", trace.ConvertSystemError(err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// credentials are the credentials used to authenticate with Grafana HTTP API
+type credentials struct {
+	// token is the API key or service account token
+	token credential
+	// username is the basic auth username
+	username credential
+	// password is the basic auth password
+	password credential
+}
+
+// credentialsFromEnv returns the credentials from the environment.
+//
+// Either the token or both the username and the password have to be set.
+func credentialsFromEnv() (*credentials, error) {
+	creds := &credentials{
+		token:    credentialFromEnv(constants.GrafanaTokenEnv, constants.GrafanaTokenFileEnv),
+		username: credentialFromEnv(constants.GrafanaUsernameEnv, constants.GrafanaUsernameFileEnv),
+		password: credentialFromEnv(constants.GrafanaPasswordEnv, constants.GrafanaPasswordFileEnv),
+	}
+	if creds.token.isSet() {
+		if creds.username.isSet() || creds.password.isSet() {
+			return nil, trace.BadParameter("%s and %s are mutually exclusive with basic auth credentials",
+				constants.GrafanaTokenEnv, constants.GrafanaTokenFileEnv)
+		}
+		return creds, nil
+	}
+	if !creds.username.isSet() {
+		return nil, trace.BadParameter("either %s or %s environment variable is required",
+			constants.GrafanaUsernameEnv, constants.GrafanaUsernameFileEnv)
+	}
+	if !creds.password.isSet() {
+		return nil, trace.BadParameter("either %s or %s environment variable is required",
+			constants.GrafanaPasswordEnv, constants.GrafanaPasswordFileEnv)
+	}
+	return creds, nil
+}
+
+// authorization returns the value of the Authorization header.
+func (c *credentials) authorization() (string, error) {
+	if c.token.isSet() {
+		token, err := c.token.get()
+		if err != nil {
+			return "", trace.Wrap(err, "failed to read Grafana token")
+		}
+		if token == "" {
+			return "", trace.BadParameter("Grafana token is empty")
+		}
+		return "Bearer " + token, nil
+	}
+	username, err := c.username.get()
+	if err != nil {
+		return "", trace.Wrap(err, "failed to read Grafana username")
+	}
+	password, err := c.password.get()
+	if err != nil {
+		return "", trace.Wrap(err, "failed to read Grafana password")
+	}
+	if username == "" || password == "" {
+		return "", trace.BadParameter("Grafana username or password is empty")
+	}
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password)), nil
+}
+
+// authTransport is the HTTP transport that sets the Authorization header
+// from the credentials on every request
+type authTransport struct {
+	// credentials are the Grafana credentials
+	credentials *credentials
+	// next is the underlying transport
+	next http.RoundTripper
+}
+
+// RoundTrip sets the Authorization header and executes the request.
+func (t *authTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	authorization, err := t.credentials.authorization()
+	if err != nil {
+		if r.Body != nil {
+			r.Body.Close()
+		}
+		return nil, trace.Wrap(err)
+	}
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", authorization)
+	return t.next.RoundTrip(r)
+}
+
+// tlsConfigFromEnv returns the TLS configuration from the environment or nil
+// if no TLS options are set.
+//
+// The client certificate and the CA certificate are read on every handshake
+// so the updates of mounted Secrets are picked up.
+func tlsConfigFromEnv() (*tls.Config, error) {
+	caPath := os.Getenv(constants.GrafanaCAFileEnv)
+	certPath := os.Getenv(constants.GrafanaCertFileEnv)
+	keyPath := os.Getenv(constants.GrafanaKeyFileEnv)
+	var insecure bool
+	if value := os.Getenv(constants.GrafanaInsecureSkipVerifyEnv); value != "" {
+		var err error
+		insecure, err = strconv.ParseBool(value)
+		if err != nil {
+			return nil, trace.BadParameter("%s environment variable must be a boolean, got %q",
+				constants.GrafanaInsecureSkipVerifyEnv, value)
+		}
+	}
+	if caPath == "" && certPath == "" && keyPath == "" && !insecure {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		InsecureSkipVerify: insecure,
+	}
+	if (certPath == "") != (keyPath == "") {
+		return nil, trace.BadParameter("%s and %s environment variables must be set together",
+			constants.GrafanaCertFileEnv, constants.GrafanaKeyFileEnv)
+	}
+	if certPath != "" {
+		if _, err := tls.LoadX509KeyPair(certPath, keyPath); err != nil {
+			return nil, trace.Wrap(err, "failed to load Grafana client certificate")
+		}
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+			if err != nil {
+				return nil, trace.Wrap(err, "failed to load Grafana client certificate")
+			}
+			return &cert, nil
+		}
+	}
+	if caPath != "" && !insecure {
+		if _, err := loadCertPool(caPath); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		// The standard verification uses the pool fixed at creation, the
+		// certificate is verified against the current CA instead.
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			return trace.Wrap(verifyConnection(state, caPath))
+		}
+	}
+	return config, nil
+}
+
+// verifyConnection verifies the server certificate of the connection
+// against the CA certificate from the specified file.
+func verifyConnection(state tls.ConnectionState, caPath string) error {
+	if len(state.PeerCertificates) == 0 {
+		return trace.AccessDenied("Grafana did not present a certificate")
+	}
+	roots, err := loadCertPool(caPath)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	options := x509.VerifyOptions{
+		DNSName:       state.ServerName,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		options.Intermediates.AddCert(cert)
+	}
+	_, err = state.PeerCertificates[0].Verify(options)
+	return trace.Wrap(err)
+}
+
+// loadCertPool returns the pool with the certificates from the specified PEM file.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, trace.BadParameter("no certificates found in %v", path)
+	}
+	return pool, nil
+}
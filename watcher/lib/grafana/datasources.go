@@ -0,0 +1,165 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+)
+
+// Datasource is a Grafana datasource
+type Datasource struct {
+	// ID is the datasource id assigned by Grafana
+	ID int64 `json:"id,omitempty"`
+	// UID is the datasource uid, generated by Grafana if not set
+	UID string `json:"uid,omitempty"`
+	// Name is the datasource name dashboards refer to the datasource by
+	Name string `json:"name"`
+	// Type is the datasource type, e.g. prometheus or loki
+	Type string `json:"type"`
+	// Access is the datasource access mode, proxy or direct
+	Access string `json:"access"`
+	// URL is the datasource URL
+	URL string `json:"url,omitempty"`
+	// Database is the datasource database name
+	Database string `json:"database,omitempty"`
+	// User is the datasource user name
+	User string `json:"user,omitempty"`
+	// BasicAuth is whether basic authentication is enabled
+	BasicAuth bool `json:"basicAuth"`
+	// BasicAuthUser is the basic authentication user name
+	BasicAuthUser string `json:"basicAuthUser,omitempty"`
+	// IsDefault is whether the datasource is the default one
+	IsDefault bool `json:"isDefault"`
+	// JSONData is the datasource type specific settings
+	JSONData map[string]interface{} `json:"jsonData,omitempty"`
+	// SecureJSONData is the datasource type specific settings stored
+	// encrypted, Grafana never returns them
+	SecureJSONData map[string]string `json:"secureJsonData,omitempty"`
+}
+
+// ManagedDatasource is a datasource created by the watcher
+type ManagedDatasource struct {
+	// Datasource is the datasource
+	Datasource
+	// Origin references the resource the datasource has been created from
+	Origin Origin
+}
+
+// UpsertDatasource creates a new or updates the existing datasource with the same name.
+//
+// The datasource is marked with the origin so it can be removed once the origin is gone.
+// Datasources not created by the watcher, e.g. the ones provisioned by the Grafana chart,
+// are not modified.
+func (c *Client) UpsertDatasource(ctx context.Context, datasource Datasource, origin Origin) error {
+	datasources, err := c.getDatasources(ctx)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	jsonData := make(map[string]interface{}, len(datasource.JSONData)+1)
+	for key, value := range datasource.JSONData {
+		jsonData[key] = value
+	}
+	jsonData[constants.DatasourceOriginField] = origin
+	datasource.JSONData = jsonData
+
+	for _, existing := range datasources {
+		if existing.Name != datasource.Name {
+			continue
+		}
+		if _, ok := existing.JSONData[constants.DatasourceOriginField]; !ok {
+			return trace.AlreadyExists("datasource %q has not been created by the watcher", datasource.Name)
+		}
+		log.Infof("Updating datasource %q.", datasource.Name)
+		datasource.ID = existing.ID
+		if datasource.UID == "" {
+			datasource.UID = existing.UID
+		}
+		response, err := c.PutJSON(ctx, c.Endpoint("api", "datasources", fmt.Sprint(existing.ID)), datasource)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		return trace.Wrap(checkResponse(response))
+	}
+
+	log.Infof("Creating datasource %q.", datasource.Name)
+	response, err := c.PostJSON(ctx, c.Endpoint("api", "datasources"), datasource)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(checkResponse(response))
+}
+
+// DeleteDatasource deletes the datasource with the specified name
+func (c *Client) DeleteDatasource(ctx context.Context, name string) error {
+	log.Infof("Deleting datasource %q.", name)
+	response, err := c.Delete(ctx, c.Endpoint("api", "datasources", "name", url.PathEscape(name)))
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(checkResponse(response))
+}
+
+// GetManagedDatasources returns datasources created by the watcher
+func (c *Client) GetManagedDatasources(ctx context.Context) ([]ManagedDatasource, error) {
+	datasources, err := c.getDatasources(ctx)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var managed []ManagedDatasource
+	for _, datasource := range datasources {
+		originJSON, ok := datasource.JSONData[constants.DatasourceOriginField]
+		if !ok {
+			continue
+		}
+		// The origin is decoded as a generic map along with the rest of JSON data.
+		data, err := json.Marshal(originJSON)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		result := ManagedDatasource{Datasource: datasource}
+		if err := json.Unmarshal(data, &result.Origin); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		managed = append(managed, result)
+	}
+	return managed, nil
+}
+
+// getDatasources returns all datasources
+func (c *Client) getDatasources(ctx context.Context) ([]Datasource, error) {
+	response, err := c.Get(ctx, c.Endpoint("api", "datasources"), url.Values{})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := checkResponse(response); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var datasources []Datasource
+	if err := json.Unmarshal(response.Bytes(), &datasources); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return datasources, nil
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+)
+
+// Folder is a Grafana dashboard folder
+type Folder struct {
+	// ID is the folder id
+	ID int64 `json:"id"`
+	// UID is the folder uid
+	UID string `json:"uid"`
+	// Title is the folder title
+	Title string `json:"title"`
+}
+
+// EnsureFolder returns the folder with the specified title and creates it if it does not exist.
+//
+// Folders created by the watcher have uids derived from their titles so they
+// can be told apart from folders created by users and deleted once empty.
+func (c *Client) EnsureFolder(ctx context.Context, title string) (*Folder, error) {
+	folders, err := c.getFolders(ctx)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	uid := managedUID(title)
+	for i, folder := range folders {
+		// Grafana requires folder titles to be unique so a folder created by
+		// a user is used as it is.
+		if folder.UID == uid || folder.Title == title {
+			return &folders[i], nil
+		}
+	}
+
+	log.Infof("Creating folder %q.", title)
+	response, err := c.PostJSON(ctx, c.Endpoint("api", "folders"), createFolderRequest{
+		UID:   uid,
+		Title: title,
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := checkResponse(response); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var folder Folder
+	if err := json.Unmarshal(response.Bytes(), &folder); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &folder, nil
+}
+
+// DeleteEmptyFolders deletes folders created by the watcher that no longer contain dashboards
+// unless they have permissions granted to teams or users.
+func (c *Client) DeleteEmptyFolders(ctx context.Context) error {
+	folders, err := c.getFolders(ctx)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	var errors []error
+	for _, folder := range folders {
+		if !isManagedUID(folder.UID) {
+			continue
+		}
+		empty, err := c.isEmptyFolder(ctx, folder.ID)
+		if err != nil {
+			errors = append(errors, trace.Wrap(err, "folder %q", folder.Title))
+			continue
+		}
+		if !empty {
+			continue
+		}
+		// Folders with permissions granted to teams or users are kept so
+		// the permissions apply once dashboards are added to them.
+		custom, err := c.hasCustomPermissions(ctx, folder.UID)
+		if err != nil {
+			errors = append(errors, trace.Wrap(err, "folder %q", folder.Title))
+			continue
+		}
+		if custom {
+			continue
+		}
+		log.Infof("Deleting empty folder %q.", folder.Title)
+		response, err := c.Delete(ctx, c.Endpoint("api", "folders", folder.UID))
+		if err == nil {
+			err = checkResponse(response)
+		}
+		if err != nil && !trace.IsNotFound(err) {
+			errors = append(errors, trace.Wrap(err, "folder %q", folder.Title))
+		}
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// getFolders returns all folders
+func (c *Client) getFolders(ctx context.Context) ([]Folder, error) {
+	response, err := c.Get(ctx, c.Endpoint("api", "folders"), url.Values{
+		"limit": []string{searchLimit},
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := checkResponse(response); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var folders []Folder
+	if err := json.Unmarshal(response.Bytes(), &folders); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return folders, nil
+}
+
+// isEmptyFolder returns true if the folder with the specified id has no dashboards
+func (c *Client) isEmptyFolder(ctx context.Context, id int64) (bool, error) {
+	response, err := c.Get(ctx, c.Endpoint("api", "search"), url.Values{
+		"type":      []string{"dash-db"},
+		"folderIds": []string{strconv.FormatInt(id, 10)},
+		"limit":     []string{"1"},
+	})
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	if err := checkResponse(response); err != nil {
+		return false, trace.Wrap(err)
+	}
+	var hits []searchHit
+	if err := json.Unmarshal(response.Bytes(), &hits); err != nil {
+		return false, trace.Wrap(err)
+	}
+	return len(hits) == 0, nil
+}
+
+// createFolderRequest is request to create a new folder
+type createFolderRequest struct {
+	// UID is the folder uid
+	UID string `json:"uid"`
+	// Title is the folder title
+	Title string `json:"title"`
+}
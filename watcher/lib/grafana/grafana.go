@@ -18,14 +18,17 @@ package grafana
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"strings"
 
 	"github.com/gravitational/monitoring-app/watcher/lib/constants"
 
-	"github.com/gosimple/slug"
 	"github.com/gravitational/roundtrip"
 	"github.com/gravitational/trace"
 	log "github.com/sirupsen/logrus"
@@ -36,16 +39,23 @@ type Client struct {
 	*roundtrip.Client
 }
 
-// NewClient returns a Grafana HTTP API client
+// NewClient returns a Grafana HTTP API client.
+//
+// The client authenticates with the API key or service account token or
+// with the username and password, set directly or by the paths of mounted
+// files in the environment. TLS options are taken from the environment too.
 func NewClient() (*Client, error) {
-	username := os.Getenv(constants.GrafanaUsernameEnv)
-	if username == "" {
-		return nil, trace.BadParameter("%s environment variable is not set", constants.GrafanaUsernameEnv)
+	creds, err := credentialsFromEnv()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if _, err := creds.authorization(); err != nil {
+		return nil, trace.Wrap(err)
 	}
 
-	password := os.Getenv(constants.GrafanaPasswordEnv)
-	if password == "" {
-		return nil, trace.BadParameter("%s environment variable is not set", constants.GrafanaPasswordEnv)
+	tlsConfig, err := tlsConfigFromEnv()
+	if err != nil {
+		return nil, trace.Wrap(err)
 	}
 
 	apiAddress := os.Getenv(constants.GrafanaApiAddrEnv)
@@ -53,7 +63,13 @@ func NewClient() (*Client, error) {
 		apiAddress = constants.GrafanaAPIAddress
 	}
 
-	client, err := roundtrip.NewClient(apiAddress, "", roundtrip.BasicAuth(username, password))
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	httpClient := &http.Client{
+		Transport: &authTransport{credentials: creds, next: transport},
+	}
+
+	client, err := roundtrip.NewClient(apiAddress, "", roundtrip.HTTPClient(httpClient))
 	if err != nil {
 		return nil, trace.Wrap(err)
 	}
@@ -71,21 +87,69 @@ func (c *Client) Health(ctx context.Context) error {
 	return nil
 }
 
-// CreateDashboard creates a new dashboard from the provided dashboard data
-func (c *Client) CreateDashboard(ctx context.Context, data string) error {
+// CreateDashboard creates a new or updates an existing dashboard from the provided dashboard data.
+//
+// The dashboard is tagged with the hash of its contents and is not uploaded again
+// if the dashboard in Grafana has the same contents and folder so unchanged
+// dashboards do not fill up the version history.
+//
+// The dashboard is identified by the uid from the data or, if the data does not
+// specify one, by the uid derived from the origin so renamed dashboards are updated
+// in place. The dashboard is marked with the origin so it can be removed once the origin is gone.
+//
+// The dashboard is placed in the folder with the specified title, which is created if needed,
+// or in the General folder if the title is empty.
+func (c *Client) CreateDashboard(ctx context.Context, data string, origin Origin, folder string) error {
 	// dashboard data should be a valid JSON
 	var dashboardJSON map[string]interface{}
 	if err := json.Unmarshal([]byte(data), &dashboardJSON); err != nil {
 		return trace.Wrap(err)
 	}
+	uid := dashboardUID(dashboardJSON, origin)
+	dashboardJSON["uid"] = uid
+	// ids are specific to Grafana instance, dashboards are matched by uid
+	delete(dashboardJSON, "id")
+	dashboardJSON[constants.DashboardOriginField] = origin
+
+	hash, err := dashboardHash(dashboardJSON)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	upToDate, err := c.dashboardUpToDate(ctx, uid, hash, folder)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if upToDate {
+		log.Debugf("Dashboard %v is up to date.", uid)
+		return nil
+	}
+	dashboardJSON["tags"] = append(withoutWatcherTags(dashboardJSON["tags"]), managedDashboardTag, dashboardHashTagPrefix+hash)
 
-	response, err := c.PostJSON(ctx, c.Endpoint("api", "dashboards", "db"), CreateDashboardRequest{
+	title, _ := dashboardJSON["title"].(string)
+	if err := c.migrateDashboard(ctx, title, uid, origin); err != nil {
+		return trace.Wrap(err)
+	}
+
+	request := CreateDashboardRequest{
 		Dashboard: dashboardJSON,
 		Overwrite: true,
-	})
+	}
+	if folder != "" {
+		dashboardFolder, err := c.EnsureFolder(ctx, folder)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		request.FolderID = dashboardFolder.ID
+		request.FolderUID = dashboardFolder.UID
+	}
+
+	response, err := c.PostJSON(ctx, c.Endpoint("api", "dashboards", "db"), request)
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	if err := checkResponse(response); err != nil {
+		return trace.Wrap(err)
+	}
 
 	log.Infof("%v", response)
 	return nil
@@ -97,23 +161,323 @@ type CreateDashboardRequest struct {
 	Dashboard map[string]interface{} `json:"dashboard"`
 	// Overwrite is whether to overwrite existing dashboard with newer version or with same dashboard title
 	Overwrite bool `json:"overwrite"`
+	// FolderID is the id of the dashboard folder, the General folder if not set
+	FolderID int64 `json:"folderId,omitempty"`
+	// FolderUID is the uid of the dashboard folder, Grafana versions before 8.0 use FolderID
+	FolderUID string `json:"folderUid,omitempty"`
 }
 
-// DeleteDashboard deletes a dashboard specified with data.
-// data is expected to be JSON-encoded and contain a field named `title` which names the dashboard to delete.
-func (c *Client) DeleteDashboard(ctx context.Context, data string) error {
-	var dashboardJSON struct {
-		Title string `json:"title"`
+// DeleteDashboard deletes the dashboard created from the provided dashboard data and origin.
+func (c *Client) DeleteDashboard(ctx context.Context, data string, origin Origin) error {
+	uid, err := DashboardUID(data, origin)
+	if err != nil {
+		return trace.Wrap(err)
 	}
-	if err := json.Unmarshal([]byte(data), &dashboardJSON); err != nil {
+	return trace.Wrap(c.DeleteDashboardByUID(ctx, uid))
+}
+
+// migrateDashboard deletes the dashboard with the specified title created
+// by the watcher versions that identified dashboards by title so it does not
+// prevent the dashboard with the specified uid from being created.
+func (c *Client) migrateDashboard(ctx context.Context, title, uid string, origin Origin) error {
+	if title == "" {
+		return nil
+	}
+	response, err := c.Get(ctx, c.Endpoint("api", "search"), url.Values{
+		"type":  []string{"dash-db"},
+		"query": []string{title},
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := checkResponse(response); err != nil {
 		return trace.Wrap(err)
 	}
+	var hits []searchHit
+	if err := json.Unmarshal(response.Bytes(), &hits); err != nil {
+		return trace.Wrap(err)
+	}
+	for _, hit := range hits {
+		if hit.Title != title || hit.UID == uid || hit.FolderID != 0 {
+			continue
+		}
+		dashboard, err := c.getDashboard(ctx, hit.UID)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if originJSON, ok := dashboard[constants.DashboardOriginField]; ok {
+			var existing Origin
+			if err := json.Unmarshal(originJSON, &existing); err != nil {
+				return trace.Wrap(err)
+			}
+			if existing != origin {
+				// The dashboard has been created from another ConfigMap.
+				continue
+			}
+		}
+		log.Infof("Migrating dashboard %q with uid %v to uid %v.", title, hit.UID, uid)
+		if err := c.DeleteDashboardByUID(ctx, hit.UID); err != nil && !trace.IsNotFound(err) {
+			return trace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+// dashboardUpToDate returns true if the dashboard with the specified uid is tagged
+// as managed and with the provided hash, its contents still match it and it is
+// in the specified folder.
+func (c *Client) dashboardUpToDate(ctx context.Context, uid, hash, folder string) (bool, error) {
+	response, err := c.Get(ctx, c.Endpoint("api", "dashboards", "uid", uid), url.Values{})
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	if err := checkResponse(response); err != nil {
+		if trace.IsNotFound(err) {
+			return false, nil
+		}
+		return false, trace.Wrap(err)
+	}
+	var existing struct {
+		Dashboard map[string]interface{} `json:"dashboard"`
+		Meta      struct {
+			FolderID    int64  `json:"folderId"`
+			FolderTitle string `json:"folderTitle"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(response.Bytes(), &existing); err != nil {
+		return false, trace.Wrap(err)
+	}
+	if (folder == "" && existing.Meta.FolderID != 0) || (folder != "" && existing.Meta.FolderTitle != folder) {
+		return false, nil
+	}
+	tags, _ := existing.Dashboard["tags"].([]interface{})
+	var managed, hashed bool
+	for _, tag := range tags {
+		switch tag {
+		case managedDashboardTag:
+			managed = true
+		case dashboardHashTagPrefix + hash:
+			hashed = true
+		}
+	}
+	// Dashboards created before the managed tag was introduced are uploaded again to add it.
+	if !managed || !hashed {
+		return false, nil
+	}
+	// The dashboard might have been changed in Grafana since it has been tagged.
+	existingHash, err := dashboardHash(existing.Dashboard)
+	if err != nil {
+		return false, trace.Wrap(err)
+	}
+	return existingHash == hash, nil
+}
 
-	response, err := c.Delete(ctx, c.Endpoint("api", "dashboards", "db", slug.Make(strings.ToLower(dashboardJSON.Title))))
+// dashboardHash returns the hash of the normalized dashboard JSON model.
+//
+// The id, version and the managed and hash tags set by Grafana or the watcher are not included.
+func dashboardHash(dashboardJSON map[string]interface{}) (string, error) {
+	// The model is marshaled and unmarshaled so it is compared in the form
+	// returned by Grafana, e.g. with the origin fields sorted.
+	data, err := json.Marshal(dashboardJSON)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	var normalized map[string]interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return "", trace.Wrap(err)
+	}
+	delete(normalized, "id")
+	delete(normalized, "version")
+	if tags := withoutWatcherTags(normalized["tags"]); len(tags) != 0 {
+		normalized["tags"] = tags
+	} else {
+		delete(normalized, "tags")
+	}
+	// Maps are marshaled with sorted keys.
+	data, err = json.Marshal(normalized)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])[:dashboardHashLength], nil
+}
+
+// withoutWatcherTags returns the provided dashboard tags without the managed and hash tags
+func withoutWatcherTags(tags interface{}) []interface{} {
+	var result []interface{}
+	list, _ := tags.([]interface{})
+	for _, tag := range list {
+		if value, ok := tag.(string); ok && (value == managedDashboardTag || strings.HasPrefix(value, dashboardHashTagPrefix)) {
+			continue
+		}
+		result = append(result, tag)
+	}
+	return result
+}
+
+// getDashboard returns the JSON model of the dashboard with the specified uid
+func (c *Client) getDashboard(ctx context.Context, uid string) (map[string]json.RawMessage, error) {
+	response, err := c.Get(ctx, c.Endpoint("api", "dashboards", "uid", uid), url.Values{})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := checkResponse(response); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var dashboard struct {
+		Dashboard map[string]json.RawMessage `json:"dashboard"`
+	}
+	if err := json.Unmarshal(response.Bytes(), &dashboard); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return dashboard.Dashboard, nil
+}
+
+// DeleteDashboardByUID deletes the dashboard with the specified UID
+func (c *Client) DeleteDashboardByUID(ctx context.Context, uid string) error {
+	response, err := c.Delete(ctx, c.Endpoint("api", "dashboards", "uid", uid))
 	if err != nil {
 		return trace.Wrap(err)
 	}
+	if err := checkResponse(response); err != nil {
+		return trace.Wrap(err)
+	}
 
 	log.Infof("%v", response)
 	return nil
 }
+
+// Origin references the ConfigMap key a dashboard has been created from
+// or the resource a datasource has been created from
+type Origin struct {
+	// Kind is the resource kind, ConfigMap if not set
+	Kind string `json:"kind,omitempty"`
+	// Namespace is the ConfigMap namespace
+	Namespace string `json:"namespace"`
+	// Name is the ConfigMap name
+	Name string `json:"name"`
+	// Key is the ConfigMap key with the dashboard data
+	Key string `json:"key"`
+}
+
+// ManagedDashboard is a dashboard created by the watcher
+type ManagedDashboard struct {
+	// UID is the dashboard UID
+	UID string
+	// Title is the dashboard title
+	Title string
+	// Origin references the ConfigMap key the dashboard has been created from
+	Origin Origin
+}
+
+// GetManagedDashboards returns dashboards created by the watcher
+//
+// Only the dashboards tagged as managed are searched for and fetched to read their origin.
+func (c *Client) GetManagedDashboards(ctx context.Context) ([]ManagedDashboard, error) {
+	response, err := c.Get(ctx, c.Endpoint("api", "search"), url.Values{
+		"type":  []string{"dash-db"},
+		"tag":   []string{managedDashboardTag},
+		"limit": []string{searchLimit},
+	})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := checkResponse(response); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var hits []searchHit
+	if err := json.Unmarshal(response.Bytes(), &hits); err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	var dashboards []ManagedDashboard
+	for _, hit := range hits {
+		dashboard, err := c.getDashboard(ctx, hit.UID)
+		if err != nil {
+			if trace.IsNotFound(err) {
+				continue
+			}
+			return nil, trace.Wrap(err)
+		}
+		originJSON, ok := dashboard[constants.DashboardOriginField]
+		if !ok {
+			continue
+		}
+		managed := ManagedDashboard{UID: hit.UID}
+		if err := json.Unmarshal(originJSON, &managed.Origin); err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if title, ok := dashboard["title"]; ok {
+			if err := json.Unmarshal(title, &managed.Title); err != nil {
+				return nil, trace.Wrap(err)
+			}
+		}
+		dashboards = append(dashboards, managed)
+	}
+	return dashboards, nil
+}
+
+// DashboardUID returns the uid of the dashboard created from the provided
+// dashboard data and origin
+func DashboardUID(data string, origin Origin) (string, error) {
+	var dashboardJSON map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &dashboardJSON); err != nil {
+		return "", trace.Wrap(err)
+	}
+	return dashboardUID(dashboardJSON, origin), nil
+}
+
+// dashboardUID returns the uid from the dashboard JSON model or the uid
+// derived from the origin if the model does not specify one
+func dashboardUID(dashboardJSON map[string]interface{}, origin Origin) string {
+	if uid, ok := dashboardJSON["uid"].(string); ok && uid != "" {
+		return uid
+	}
+	hash := sha256.Sum256([]byte(path.Join(origin.Namespace, origin.Name, origin.Key)))
+	return hex.EncodeToString(hash[:])[:maxUIDLength]
+}
+
+// managedUID returns the uid of the Grafana object created by the watcher
+// derived from the provided seed
+func managedUID(seed string) string {
+	hash := sha256.Sum256([]byte(seed))
+	return managedUIDPrefix + hex.EncodeToString(hash[:])[:maxUIDLength-len(managedUIDPrefix)]
+}
+
+// isManagedUID returns true if the Grafana object with the specified uid has been created by the watcher
+func isManagedUID(uid string) bool {
+	return strings.HasPrefix(uid, managedUIDPrefix) && len(uid) == maxUIDLength
+}
+
+// searchHit is a dashboard returned by search
+type searchHit struct {
+	// UID is the dashboard uid
+	UID string `json:"uid"`
+	// Title is the dashboard title
+	Title string `json:"title"`
+	// FolderID is the id of the dashboard folder, 0 for the General folder
+	FolderID int64 `json:"folderId"`
+}
+
+// checkResponse returns an error if the response has non-2xx status code
+func checkResponse(response *roundtrip.Response) error {
+	if response.Code() < http.StatusOK || response.Code() >= http.StatusMultipleChoices {
+		return trace.ReadError(response.Code(), response.Bytes())
+	}
+	return nil
+}
+
+const (
+	// searchLimit is the maximum number of dashboards returned by search
+	searchLimit = "5000"
+	// maxUIDLength is the maximum length of dashboard uid supported by Grafana
+	maxUIDLength = 40
+	// managedUIDPrefix is the prefix of uids of folders and notifiers created by the watcher
+	managedUIDPrefix = "watcher-"
+	// managedDashboardTag is the tag of dashboards created by the watcher
+	managedDashboardTag = "watcher-managed"
+	// dashboardHashTagPrefix is the prefix of the dashboard tag with the hash of dashboard contents
+	dashboardHashTagPrefix = "watcher-hash:"
+	// dashboardHashLength is the length of the dashboard hash, Grafana tags are limited to 50 characters
+	dashboardHashLength = 32
+)
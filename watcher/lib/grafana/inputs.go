@@ -0,0 +1,181 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/gravitational/trace"
+)
+
+// DashboardInput is an input of an exported dashboard
+type DashboardInput struct {
+	// Name is the input name referenced as ${Name} in the dashboard
+	Name string `json:"name"`
+	// Type is the input type, datasource or constant
+	Type string `json:"type"`
+	// PluginID is the type of the datasource of datasource inputs
+	PluginID string `json:"pluginId,omitempty"`
+	// Value is the default value of constant inputs
+	Value string `json:"value,omitempty"`
+}
+
+// ResolveInputs substitutes the inputs declared in the __inputs block of the
+// exported dashboard with the provided values the way dashboard import does
+// and returns the resulting dashboard data.
+//
+// Values are datasource names for datasource inputs. Datasource inputs without
+// a value are set to the default datasource of the input type or the first one
+// by name. Constant inputs without a value keep their default value. The data
+// is returned unchanged if the dashboard declares no inputs.
+func (c *Client) ResolveInputs(ctx context.Context, data string, values map[string]string) (string, error) {
+	var dashboardJSON map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &dashboardJSON); err != nil {
+		return "", trace.Wrap(err)
+	}
+	inputsJSON, ok := dashboardJSON[inputsField]
+	if !ok {
+		return data, nil
+	}
+	var inputs []DashboardInput
+	if err := remarshal(inputsJSON, &inputs); err != nil {
+		return "", trace.Wrap(err, "invalid %v", inputsField)
+	}
+
+	var datasources []Datasource
+	resolved := make(map[string]resolvedInput, len(inputs))
+	for _, input := range inputs {
+		value, ok := values[input.Name]
+		switch input.Type {
+		case inputTypeDatasource:
+			if datasources == nil {
+				var err error
+				if datasources, err = c.getDatasources(ctx); err != nil {
+					return "", trace.Wrap(err)
+				}
+			}
+			datasource, err := inputDatasource(datasources, input, value)
+			if err != nil {
+				return "", trace.Wrap(err)
+			}
+			resolved[input.Name] = resolvedInput{value: datasource.Name, uid: datasource.UID}
+		case inputTypeConstant:
+			if !ok {
+				value = input.Value
+			}
+			resolved[input.Name] = resolvedInput{value: value}
+		default:
+			return "", trace.BadParameter("input %q has unsupported type %q", input.Name, input.Type)
+		}
+	}
+
+	delete(dashboardJSON, inputsField)
+	result, err := json.Marshal(substituteInputs(dashboardJSON, "", resolved))
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	return string(result), nil
+}
+
+// inputDatasource returns the datasource for the datasource input, the one
+// with the specified name or, if the name is empty, the default one of the input type.
+func inputDatasource(datasources []Datasource, input DashboardInput, name string) (*Datasource, error) {
+	if name != "" {
+		for _, datasource := range datasources {
+			if datasource.Name == name {
+				return &datasource, nil
+			}
+		}
+		return nil, trace.NotFound("datasource %q for input %q not found", name, input.Name)
+	}
+	var candidates []Datasource
+	for _, datasource := range datasources {
+		if datasource.Type == input.PluginID {
+			candidates = append(candidates, datasource)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, trace.NotFound("no %v datasource for input %q", input.PluginID, input.Name)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].IsDefault != candidates[j].IsDefault {
+			return candidates[i].IsDefault
+		}
+		return candidates[i].Name < candidates[j].Name
+	})
+	return &candidates[0], nil
+}
+
+// resolvedInput is the value of a dashboard input
+type resolvedInput struct {
+	// value is the input value
+	value string
+	// uid is the uid of the datasource of datasource inputs
+	uid string
+}
+
+// substituteInputs replaces the ${name} references to the provided inputs
+// in the strings of the JSON value with the specified key.
+//
+// Datasource inputs are replaced with the datasource uid in uid fields as
+// dashboards exported by Grafana 8.3 and newer reference datasources by uid.
+func substituteInputs(value interface{}, key string, inputs map[string]resolvedInput) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for k, v := range value {
+			value[k] = substituteInputs(v, k, inputs)
+		}
+		return value
+	case []interface{}:
+		for i, v := range value {
+			value[i] = substituteInputs(v, key, inputs)
+		}
+		return value
+	case string:
+		for name, input := range inputs {
+			reference := "${" + name + "}"
+			if key == "uid" && value == reference && input.uid != "" {
+				return input.uid
+			}
+			value = strings.ReplaceAll(value, reference, input.value)
+		}
+		return value
+	default:
+		return value
+	}
+}
+
+// remarshal converts the unmarshaled JSON value to the provided type
+func remarshal(value interface{}, out interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return trace.Wrap(json.Unmarshal(data, out))
+}
+
+const (
+	// inputsField is the dashboard JSON field with the inputs of exported dashboards
+	inputsField = "__inputs"
+	// inputTypeDatasource is the type of inputs set to a datasource
+	inputTypeDatasource = "datasource"
+	// inputTypeConstant is the type of inputs set to a constant value
+	inputTypeConstant = "constant"
+)
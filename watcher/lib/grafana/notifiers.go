@@ -0,0 +1,638 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grafana
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"reflect"
+	"sort"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+)
+
+// AlertingSystem is the alerting system used by Grafana
+type AlertingSystem string
+
+const (
+	// LegacyAlerting is the dashboard alerting that sends notifications to notification channels
+	LegacyAlerting AlertingSystem = "legacy"
+	// UnifiedAlerting is the alerting introduced in Grafana 8 that routes
+	// notifications to contact points with notification policies
+	UnifiedAlerting AlertingSystem = "unified"
+)
+
+// Notifier is a notification channel or, with unified alerting, a contact point
+type Notifier struct {
+	// Name is the notifier name
+	Name string
+	// Type is the notifier type, e.g. slack or email
+	Type string
+	// IsDefault is whether notifications are sent to the notifier by default
+	IsDefault bool
+	// DisableResolveMessage is whether to not notify when alerts are resolved
+	DisableResolveMessage bool
+	// Settings is the notifier type specific settings
+	Settings map[string]interface{}
+	// SecureSettings is the notifier type specific settings stored encrypted,
+	// Grafana never returns them
+	SecureSettings map[string]string
+	// Matchers is the label values of alerts routed to the contact point by a
+	// notification policy, no policy is created if empty. Only used with unified alerting.
+	Matchers map[string]string
+	// Continue is whether alerts routed to the contact point continue matching subsequent policies
+	Continue bool
+}
+
+// uid returns the uid of the notification channel or contact point created from the notifier
+func (n Notifier) uid() string {
+	return managedUID(path.Join("notifier", n.Name))
+}
+
+// GetAlertingSystem returns the alerting system used by Grafana
+func (c *Client) GetAlertingSystem(ctx context.Context) (AlertingSystem, error) {
+	response, err := c.Get(ctx, c.Endpoint("api", "frontend", "settings"), url.Values{})
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	if err := checkResponse(response); err != nil {
+		return "", trace.Wrap(err)
+	}
+	var settings frontendSettings
+	if err := json.Unmarshal(response.Bytes(), &settings); err != nil {
+		return "", trace.Wrap(err)
+	}
+	if settings.UnifiedAlertingEnabled != nil {
+		if *settings.UnifiedAlertingEnabled {
+			return UnifiedAlerting, nil
+		}
+		return LegacyAlerting, nil
+	}
+	if settings.FeatureToggles[ngalertFeatureToggle] {
+		return UnifiedAlerting, nil
+	}
+	return LegacyAlerting, nil
+}
+
+// SyncNotifiers creates or updates the provided notifiers as notification channels
+// or contact points, depending on the alerting system Grafana uses, and deletes the
+// ones created by the watcher that are neither provided nor retained.
+//
+// Notifiers with the retained names are left as they are.
+func (c *Client) SyncNotifiers(ctx context.Context, notifiers []Notifier, retain []string) error {
+	system, err := c.GetAlertingSystem(ctx)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if system == UnifiedAlerting {
+		return trace.Wrap(c.syncContactPoints(ctx, notifiers, retain))
+	}
+	return trace.Wrap(c.syncNotificationChannels(ctx, notifiers, retain))
+}
+
+// syncNotificationChannels syncs the notifiers as legacy alerting notification channels
+func (c *Client) syncNotificationChannels(ctx context.Context, notifiers []Notifier, retain []string) error {
+	response, err := c.Get(ctx, c.Endpoint("api", "alert-notifications"), url.Values{})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := checkResponse(response); err != nil {
+		return trace.Wrap(err)
+	}
+	var channels []notificationChannel
+	if err := json.Unmarshal(response.Bytes(), &channels); err != nil {
+		return trace.Wrap(err)
+	}
+	existing := make(map[string]notificationChannel, len(channels))
+	for _, channel := range channels {
+		existing[channel.UID] = channel
+	}
+
+	keep := make(map[string]struct{})
+	for _, name := range retain {
+		keep[Notifier{Name: name}.uid()] = struct{}{}
+	}
+	var errors []error
+	for _, notifier := range notifiers {
+		channel := newNotificationChannel(notifier)
+		keep[channel.UID] = struct{}{}
+		if _, ok := existing[channel.UID]; ok {
+			log.Infof("Updating notification channel %q.", notifier.Name)
+			response, err = c.PutJSON(ctx, c.Endpoint("api", "alert-notifications", "uid", channel.UID), channel)
+		} else {
+			if isNotifierNameTaken(channels, notifier.Name) {
+				errors = append(errors, trace.AlreadyExists(
+					"notification channel %q has not been created by the watcher", notifier.Name))
+				continue
+			}
+			log.Infof("Creating notification channel %q.", notifier.Name)
+			response, err = c.PostJSON(ctx, c.Endpoint("api", "alert-notifications"), channel)
+		}
+		if err == nil {
+			err = checkResponse(response)
+		}
+		if err != nil {
+			errors = append(errors, trace.Wrap(err, "notification channel %q", notifier.Name))
+		}
+	}
+
+	for _, channel := range channels {
+		if _, ok := keep[channel.UID]; ok || !isManagedUID(channel.UID) {
+			continue
+		}
+		log.Infof("Deleting notification channel %q.", channel.Name)
+		response, err := c.Delete(ctx, c.Endpoint("api", "alert-notifications", "uid", channel.UID))
+		if err == nil {
+			err = checkResponse(response)
+		}
+		if err != nil && !trace.IsNotFound(err) {
+			errors = append(errors, trace.Wrap(err, "notification channel %q", channel.Name))
+		}
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// isNotifierNameTaken returns true if a notification channel not created by
+// the watcher has the specified name
+func isNotifierNameTaken(channels []notificationChannel, name string) bool {
+	for _, channel := range channels {
+		if channel.Name == name && !isManagedUID(channel.UID) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncContactPoints syncs the notifiers as unified alerting contact points
+// along with the notification policies routing alerts to them.
+//
+// Notification policies routing alerts to contact points created by the
+// watcher are managed by the watcher, other policies and contact points of
+// the Grafana Alertmanager configuration are left as they are.
+func (c *Client) syncContactPoints(ctx context.Context, notifiers []Notifier, retain []string) error {
+	endpoint := c.Endpoint("api", "alertmanager", "grafana", "config", "api", "v1", "alerts")
+	response, err := c.Get(ctx, endpoint, url.Values{})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := checkResponse(response); err != nil {
+		return trace.Wrap(err)
+	}
+	var config alertmanagerConfig
+	if err := json.Unmarshal(response.Bytes(), &config); err != nil {
+		return trace.Wrap(err)
+	}
+	if config.AlertmanagerConfig == nil {
+		config.AlertmanagerConfig = make(map[string]json.RawMessage)
+	}
+	existingRoute := config.AlertmanagerConfig["route"]
+	var receivers []json.RawMessage
+	if err := unmarshalField(config.AlertmanagerConfig, "receivers", &receivers); err != nil {
+		return trace.Wrap(err)
+	}
+	var route map[string]json.RawMessage
+	if err := unmarshalField(config.AlertmanagerConfig, "route", &route); err != nil {
+		return trace.Wrap(err)
+	}
+	if route == nil {
+		route = make(map[string]json.RawMessage)
+	}
+	var routes []json.RawMessage
+	if err := unmarshalField(route, "routes", &routes); err != nil {
+		return trace.Wrap(err)
+	}
+
+	keep := make(map[string]struct{})
+	for _, name := range retain {
+		keep[name] = struct{}{}
+	}
+	// managed is the set of names of contact points created by the watcher
+	// that are not retained, they are replaced with the provided ones
+	managed := make(map[string]struct{})
+	// taken is the set of names of contact points that are kept as they are
+	taken := make(map[string]struct{})
+	var newReceivers []interface{}
+	for _, data := range receivers {
+		var receiver contactPoint
+		if err := json.Unmarshal(data, &receiver); err != nil {
+			return trace.Wrap(err)
+		}
+		if _, ok := keep[receiver.Name]; !ok && receiver.isManaged() {
+			managed[receiver.Name] = struct{}{}
+			continue
+		}
+		taken[receiver.Name] = struct{}{}
+		newReceivers = append(newReceivers, data)
+	}
+	if len(managed) == 0 && len(notifiers) == 0 {
+		return nil
+	}
+	var newRoutes []interface{}
+	for _, data := range routes {
+		var childRoute struct {
+			Receiver string `json:"receiver"`
+		}
+		if err := json.Unmarshal(data, &childRoute); err != nil {
+			return trace.Wrap(err)
+		}
+		if _, ok := managed[childRoute.Receiver]; ok {
+			continue
+		}
+		newRoutes = append(newRoutes, data)
+	}
+
+	var errors []error
+	var defaultReceiver string
+	// updated is the list of names of contact points created by the watcher
+	var updated []string
+	for _, notifier := range notifiers {
+		if _, ok := taken[notifier.Name]; ok {
+			errors = append(errors, trace.AlreadyExists(
+				"contact point %q has not been created by the watcher", notifier.Name))
+			continue
+		}
+		updated = append(updated, notifier.Name)
+		newReceivers = append(newReceivers, newContactPoint(notifier))
+		if len(notifier.Matchers) != 0 {
+			newRoutes = append(newRoutes, newNotificationPolicy(notifier))
+		}
+		if notifier.IsDefault {
+			defaultReceiver = notifier.Name
+		}
+	}
+
+	var rootReceiver string
+	if err := unmarshalField(route, "receiver", &rootReceiver); err != nil {
+		return trace.Wrap(err)
+	}
+	if defaultReceiver == "" {
+		if _, ok := managed[rootReceiver]; ok {
+			// The default contact point has been removed, fall back to the
+			// first remaining contact point.
+			for _, data := range newReceivers {
+				var receiver contactPoint
+				if err := unmarshalReceiver(data, &receiver); err != nil {
+					return trace.Wrap(err)
+				}
+				defaultReceiver = receiver.Name
+				break
+			}
+		}
+	}
+	if defaultReceiver != "" && defaultReceiver != rootReceiver {
+		log.Infof("Setting default contact point to %q.", defaultReceiver)
+		if err := marshalField(route, "receiver", defaultReceiver); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	if len(routes) != 0 || len(newRoutes) != 0 {
+		if err := marshalField(route, "routes", newRoutes); err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	if err := marshalField(config.AlertmanagerConfig, "route", route); err != nil {
+		return trace.Wrap(err)
+	}
+	if err := marshalField(config.AlertmanagerConfig, "receivers", newReceivers); err != nil {
+		return trace.Wrap(err)
+	}
+
+	unchanged, err := contactPointsUnchanged(receivers, newReceivers)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if unchanged {
+		unchanged, err = jsonEqual(existingRoute, config.AlertmanagerConfig["route"])
+		if err != nil {
+			return trace.Wrap(err)
+		}
+	}
+	if unchanged {
+		log.Debug("Contact points are up to date.")
+		return trace.NewAggregate(errors...)
+	}
+	for _, name := range updated {
+		log.Infof("Updating contact point %q.", name)
+	}
+
+	response, err = c.PostJSON(ctx, endpoint, config)
+	if err != nil {
+		return trace.NewAggregate(append(errors, trace.Wrap(err))...)
+	}
+	if err := checkResponse(response); err != nil {
+		return trace.NewAggregate(append(errors, trace.Wrap(err))...)
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// contactPointsUnchanged returns true if the contact points about to be posted
+// match the existing ones returned by Grafana. Grafana does not return secure
+// settings so they are compared by the hash recorded in the settings.
+func contactPointsUnchanged(existing []json.RawMessage, receivers []interface{}) (bool, error) {
+	if len(existing) != len(receivers) {
+		return false, nil
+	}
+	for i, data := range receivers {
+		switch receiver := data.(type) {
+		case json.RawMessage:
+			if string(receiver) != string(existing[i]) {
+				return false, nil
+			}
+		case contactPoint:
+			var stored storedContactPoint
+			if err := json.Unmarshal(existing[i], &stored); err != nil {
+				return false, trace.Wrap(err)
+			}
+			equal, err := stored.matches(receiver)
+			if err != nil || !equal {
+				return false, trace.Wrap(err)
+			}
+		default:
+			return false, trace.BadParameter("unexpected contact point %T", data)
+		}
+	}
+	return true, nil
+}
+
+// jsonEqual returns true if the provided JSON documents are equal
+func jsonEqual(a, b json.RawMessage) (bool, error) {
+	var valueA, valueB interface{}
+	if len(a) != 0 {
+		if err := json.Unmarshal(a, &valueA); err != nil {
+			return false, trace.Wrap(err)
+		}
+	}
+	if len(b) != 0 {
+		if err := json.Unmarshal(b, &valueB); err != nil {
+			return false, trace.Wrap(err)
+		}
+	}
+	return reflect.DeepEqual(valueA, valueB), nil
+}
+
+// secureSettingsHash returns the hash of the provided secure settings
+func secureSettingsHash(settings map[string]string) string {
+	// Maps are marshaled with sorted keys.
+	data, _ := json.Marshal(settings)
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// newNotificationChannel returns the legacy notification channel created from the notifier
+func newNotificationChannel(notifier Notifier) notificationChannel {
+	channel := notificationChannel{
+		UID:                   notifier.uid(),
+		Name:                  notifier.Name,
+		Type:                  notifier.Type,
+		IsDefault:             notifier.IsDefault,
+		DisableResolveMessage: notifier.DisableResolveMessage,
+		Settings:              notifier.Settings,
+		SecureSettings:        notifier.SecureSettings,
+	}
+	if channel.Settings == nil {
+		channel.Settings = make(map[string]interface{})
+	}
+	return channel
+}
+
+// newContactPoint returns the contact point created from the notifier.
+//
+// The hash of the secure settings is recorded in the settings so changes of
+// secure settings are detected after the watcher restarts.
+func newContactPoint(notifier Notifier) contactPoint {
+	settings := make(map[string]interface{}, len(notifier.Settings)+1)
+	for name, value := range notifier.Settings {
+		settings[name] = value
+	}
+	if len(notifier.SecureSettings) != 0 {
+		settings[constants.NotifierSecureSettingsHashField] = secureSettingsHash(notifier.SecureSettings)
+	}
+	receiver := receiverConfig{
+		UID:                   notifier.uid(),
+		Name:                  notifier.Name,
+		Type:                  notifier.Type,
+		DisableResolveMessage: notifier.DisableResolveMessage,
+		Settings:              settings,
+		SecureSettings:        notifier.SecureSettings,
+	}
+	return contactPoint{
+		Name:      notifier.Name,
+		Receivers: []receiverConfig{receiver},
+	}
+}
+
+// newNotificationPolicy returns the notification policy routing alerts to the notifier contact point
+func newNotificationPolicy(notifier Notifier) notificationPolicy {
+	policy := notificationPolicy{
+		Receiver: notifier.Name,
+		Continue: notifier.Continue,
+	}
+	for name, value := range notifier.Matchers {
+		policy.Matchers = append(policy.Matchers, fmt.Sprintf("%v=%q", name, value))
+	}
+	sort.Strings(policy.Matchers)
+	return policy
+}
+
+// unmarshalReceiver decodes the contact point that is either kept as received from Grafana or created by the watcher
+func unmarshalReceiver(data interface{}, receiver *contactPoint) error {
+	switch data := data.(type) {
+	case json.RawMessage:
+		return trace.Wrap(json.Unmarshal(data, receiver))
+	case contactPoint:
+		*receiver = data
+		return nil
+	default:
+		return trace.BadParameter("unexpected contact point %T", data)
+	}
+}
+
+// unmarshalField decodes the specified field of the JSON object if it is set
+func unmarshalField(object map[string]json.RawMessage, field string, value interface{}) error {
+	data, ok := object[field]
+	if !ok || string(data) == "null" {
+		return nil
+	}
+	return trace.Wrap(json.Unmarshal(data, value))
+}
+
+// marshalField encodes the value as the specified field of the JSON object
+func marshalField(object map[string]json.RawMessage, field string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	object[field] = data
+	return nil
+}
+
+// frontendSettings is the part of Grafana frontend settings that describes the alerting system
+type frontendSettings struct {
+	// UnifiedAlertingEnabled is whether unified alerting is enabled, reported by Grafana 8.2 and newer
+	UnifiedAlertingEnabled *bool `json:"unifiedAlertingEnabled"`
+	// FeatureToggles is the set of enabled feature toggles
+	FeatureToggles map[string]bool `json:"featureToggles"`
+}
+
+// notificationChannel is a legacy alerting notification channel
+type notificationChannel struct {
+	// UID is the channel uid
+	UID string `json:"uid"`
+	// Name is the channel name
+	Name string `json:"name"`
+	// Type is the channel type
+	Type string `json:"type"`
+	// IsDefault is whether all alerts are sent to the channel
+	IsDefault bool `json:"isDefault"`
+	// DisableResolveMessage is whether to not notify when alerts are resolved
+	DisableResolveMessage bool `json:"disableResolveMessage"`
+	// Settings is the channel type specific settings
+	Settings map[string]interface{} `json:"settings"`
+	// SecureSettings is the channel type specific settings stored encrypted
+	SecureSettings map[string]string `json:"secureSettings,omitempty"`
+}
+
+// alertmanagerConfig is the configuration of the Grafana Alertmanager
+type alertmanagerConfig struct {
+	// TemplateFiles is the notification templates
+	TemplateFiles map[string]string `json:"template_files"`
+	// AlertmanagerConfig is the Alertmanager configuration, decoded partially
+	// so the fields the watcher does not manage are kept as they are
+	AlertmanagerConfig map[string]json.RawMessage `json:"alertmanager_config"`
+}
+
+// contactPoint is a unified alerting contact point
+type contactPoint struct {
+	// Name is the contact point name
+	Name string `json:"name"`
+	// Receivers is the list of integrations of the contact point
+	Receivers []receiverConfig `json:"grafana_managed_receiver_configs"`
+}
+
+// isManaged returns true if the contact point has been created by the watcher
+func (r contactPoint) isManaged() bool {
+	for _, receiver := range r.Receivers {
+		if isManagedUID(receiver.UID) {
+			return true
+		}
+	}
+	return false
+}
+
+// receiverConfig is an integration of a contact point
+type receiverConfig struct {
+	// UID is the integration uid
+	UID string `json:"uid"`
+	// Name is the integration name
+	Name string `json:"name"`
+	// Type is the integration type
+	Type string `json:"type"`
+	// DisableResolveMessage is whether to not notify when alerts are resolved
+	DisableResolveMessage bool `json:"disableResolveMessage"`
+	// Settings is the integration type specific settings
+	Settings map[string]interface{} `json:"settings"`
+	// SecureSettings is the integration type specific settings stored encrypted
+	SecureSettings map[string]string `json:"secureSettings,omitempty"`
+}
+
+// storedContactPoint is a unified alerting contact point as returned by Grafana
+type storedContactPoint struct {
+	// Name is the contact point name
+	Name string `json:"name"`
+	// Receivers is the list of integrations of the contact point
+	Receivers []storedReceiverConfig `json:"grafana_managed_receiver_configs"`
+}
+
+// matches returns true if the contact point matches the provided one about to be posted
+func (r storedContactPoint) matches(other contactPoint) (bool, error) {
+	if r.Name != other.Name || len(r.Receivers) != len(other.Receivers) {
+		return false, nil
+	}
+	for i, receiver := range other.Receivers {
+		stored := r.Receivers[i]
+		if stored.UID != receiver.UID || stored.Name != receiver.Name || stored.Type != receiver.Type ||
+			stored.DisableResolveMessage != receiver.DisableResolveMessage {
+			return false, nil
+		}
+		secureFields := make(map[string]bool, len(receiver.SecureSettings))
+		for name := range receiver.SecureSettings {
+			secureFields[name] = true
+		}
+		if !reflect.DeepEqual(stored.secureFields(), secureFields) {
+			return false, nil
+		}
+		settings, err := json.Marshal(receiver.Settings)
+		if err != nil {
+			return false, trace.Wrap(err)
+		}
+		equal, err := jsonEqual(stored.Settings, settings)
+		if err != nil || !equal {
+			return false, trace.Wrap(err)
+		}
+	}
+	return true, nil
+}
+
+// storedReceiverConfig is an integration of a contact point as returned by Grafana
+type storedReceiverConfig struct {
+	// UID is the integration uid
+	UID string `json:"uid"`
+	// Name is the integration name
+	Name string `json:"name"`
+	// Type is the integration type
+	Type string `json:"type"`
+	// DisableResolveMessage is whether to not notify when alerts are resolved
+	DisableResolveMessage bool `json:"disableResolveMessage"`
+	// Settings is the integration type specific settings
+	Settings json.RawMessage `json:"settings"`
+	// SecureFields is the set of names of secure settings that are set
+	SecureFields map[string]bool `json:"secureFields"`
+}
+
+// secureFields returns the set of names of secure settings that are set
+func (r storedReceiverConfig) secureFields() map[string]bool {
+	fields := make(map[string]bool, len(r.SecureFields))
+	for name, set := range r.SecureFields {
+		if set {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// notificationPolicy is a unified alerting notification policy
+type notificationPolicy struct {
+	// Receiver is the name of the contact point alerts are routed to
+	Receiver string `json:"receiver"`
+	// Matchers is the list of label matchers of routed alerts
+	Matchers []string `json:"matchers"`
+	// Continue is whether routed alerts continue matching subsequent policies
+	Continue bool `json:"continue"`
+}
+
+const (
+	// ngalertFeatureToggle is the feature toggle that enables unified alerting in Grafana 8.0 and 8.1
+	ngalertFeatureToggle = "ngalert"
+)
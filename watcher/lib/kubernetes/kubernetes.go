@@ -31,6 +31,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/selection"
 	"k8s.io/apimachinery/pkg/util/runtime"
@@ -207,6 +208,105 @@ type ResourceUpdate struct {
 	metav1.ObjectMeta
 }
 
+// ObjectReference returns a reference to the updated resource of the specified kind.
+//
+// The kind has to be provided explicitly since objects received from
+// watchers do not have their type metadata populated.
+func (r ResourceUpdate) ObjectReference(kind string) v1.ObjectReference {
+	return v1.ObjectReference{
+		Kind:            kind,
+		APIVersion:      v1.SchemeGroupVersion.String(),
+		Namespace:       r.Namespace,
+		Name:            r.Name,
+		UID:             r.UID,
+		ResourceVersion: r.ResourceVersion,
+	}
+}
+
+// OwnerReference returns a reference to the updated resource of the specified
+// kind suitable for marking resources created from it as owned by it.
+func (r ResourceUpdate) OwnerReference(kind string) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: v1.SchemeGroupVersion.String(),
+		Kind:       kind,
+		Name:       r.Name,
+		UID:        r.UID,
+	}
+}
+
+// RecordEvent records a Kubernetes event of the specified type about the
+// referenced object.
+//
+// If the same event has already been recorded about the object, its count
+// is incremented instead so resources applied again, e.g. after restarts,
+// do not accumulate identical events.
+func RecordEvent(ctx context.Context, events corev1.EventInterface, object v1.ObjectReference, eventType, reason, message string) error {
+	now := metav1.Now()
+	existing, err := findEvent(ctx, events, object, eventType, reason, message)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if existing != nil {
+		existing.Count++
+		existing.LastTimestamp = now
+		_, err := events.Update(ctx, existing, metav1.UpdateOptions{})
+		return trace.Wrap(err)
+	}
+	_, err = events.Create(ctx, &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: fmt.Sprintf("%v.", object.Name),
+			Namespace:    object.Namespace,
+		},
+		InvolvedObject: object,
+		Type:           eventType,
+		Reason:         reason,
+		Message:        message,
+		Source:         v1.EventSource{Component: eventSourceComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return nil
+}
+
+// findEvent returns the event with the specified type, reason and message
+// recorded about the referenced object or nil if there is none.
+func findEvent(ctx context.Context, events corev1.EventInterface, object v1.ObjectReference, eventType, reason, message string) (*v1.Event, error) {
+	selector := fields.Set{
+		"involvedObject.kind": object.Kind,
+		"involvedObject.name": object.Name,
+		"type":                eventType,
+		"reason":              reason,
+		"source":              eventSourceComponent,
+	}
+	if object.UID != "" {
+		selector["involvedObject.uid"] = string(object.UID)
+	}
+	list, err := events.List(ctx, metav1.ListOptions{FieldSelector: selector.String()})
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	for i, event := range list.Items {
+		if event.Message == message {
+			return &list.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+const (
+	// KindConfigMap is the ConfigMap resource kind.
+	KindConfigMap = "ConfigMap"
+	// KindSecret is the Secret resource kind.
+	KindSecret = "Secret"
+)
+
+// eventSourceComponent is the component name recorded in Kubernetes events.
+const eventSourceComponent = "monitoring-watcher"
+
 func watchConfigMap(ctx context.Context, client corev1.ConfigMapInterface, config ConfigMap) error {
 	watcher, err := client.Watch(ctx, metav1.ListOptions{LabelSelector: config.Selector.String()})
 	if err != nil {
@@ -0,0 +1,174 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package lint checks alerting rules against a configurable policy.
+package lint
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"time"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/resources"
+	"github.com/gravitational/monitoring-app/watcher/lib/utils"
+
+	"github.com/ghodss/yaml"
+	"github.com/gravitational/trace"
+	"github.com/prometheus/common/model"
+)
+
+// Mode defines how policy violations are handled.
+type Mode string
+
+const (
+	// ModeWarn reports policy violations but still applies the alerts.
+	ModeWarn Mode = "warn"
+	// ModeEnforce rejects alerts that violate the policy.
+	ModeEnforce Mode = "enforce"
+)
+
+// Policy defines the requirements alerting rules have to satisfy.
+type Policy struct {
+	// Mode defines how policy violations are handled.
+	Mode Mode `json:"mode"`
+	// RequiredLabels is the list of labels every alert must have.
+	RequiredLabels []string `json:"requiredLabels,omitempty"`
+	// RequiredAnnotations is the list of annotations every alert must have.
+	RequiredAnnotations []string `json:"requiredAnnotations,omitempty"`
+	// AllowedSeverities optionally restricts the values of the severity label.
+	AllowedSeverities []string `json:"allowedSeverities,omitempty"`
+	// MinFor is the minimum duration the alert expression has to hold
+	// before the alert fires.
+	MinFor model.Duration `json:"minFor,omitempty"`
+	// ForbiddenPatterns is the list of patterns alert expressions must not match.
+	ForbiddenPatterns []ForbiddenPattern `json:"forbiddenPatterns,omitempty"`
+}
+
+// ForbiddenPattern is a regular expression alert expressions must not match.
+type ForbiddenPattern struct {
+	// Pattern is the regular expression.
+	Pattern string `json:"pattern"`
+	// Message optionally explains why the pattern is forbidden.
+	Message string `json:"message,omitempty"`
+
+	regexp *regexp.Regexp
+}
+
+// RecommendedPolicy returns the policy the lint command checks alerts
+// against if no policy file is specified.
+func RecommendedPolicy() Policy {
+	return Policy{
+		Mode:                ModeWarn,
+		RequiredLabels:      []string{severityLabel},
+		RequiredAnnotations: []string{"summary", "description", "runbook_url"},
+	}
+}
+
+// LoadPolicy reads the policy from the specified file. An empty policy
+// that does not require anything is returned if the path is empty.
+func LoadPolicy(path string) (*Policy, error) {
+	if path == "" {
+		return &Policy{Mode: ModeWarn}, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	var policy Policy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if err := policy.CheckAndSetDefaults(); err != nil {
+		return nil, trace.Wrap(err, "invalid policy %v", path)
+	}
+	return &policy, nil
+}
+
+// CheckAndSetDefaults validates the policy and sets default values.
+func (p *Policy) CheckAndSetDefaults() error {
+	switch p.Mode {
+	case "":
+		p.Mode = ModeWarn
+	case ModeWarn, ModeEnforce:
+	default:
+		return trace.BadParameter("unknown mode %q, expected %q or %q", p.Mode, ModeWarn, ModeEnforce)
+	}
+	for i, pattern := range p.ForbiddenPatterns {
+		re, err := regexp.Compile(pattern.Pattern)
+		if err != nil {
+			return trace.BadParameter("invalid forbidden pattern %q: %v", pattern.Pattern, err)
+		}
+		p.ForbiddenPatterns[i].regexp = re
+	}
+	return nil
+}
+
+// Lint returns the policy violations of the alerting rules in the provided
+// groups. Recording rules are not checked.
+func (p Policy) Lint(groups []resources.RuleGroup) (violations []string) {
+	for _, group := range groups {
+		for _, rule := range group.Rules {
+			if rule.Alert == "" {
+				continue
+			}
+			for _, violation := range p.lintRule(rule) {
+				violations = append(violations, fmt.Sprintf("group %q, alert %q: %v",
+					group.Name, rule.Alert, violation))
+			}
+		}
+	}
+	return violations
+}
+
+// Enforced returns true if alerts violating the policy must be rejected.
+func (p Policy) Enforced() bool {
+	return p.Mode == ModeEnforce
+}
+
+// lintRule returns the policy violations of the alerting rule.
+func (p Policy) lintRule(rule resources.Rule) (violations []string) {
+	for _, label := range p.RequiredLabels {
+		if rule.Labels[label] == "" {
+			violations = append(violations, fmt.Sprintf("missing label %q", label))
+		}
+	}
+	for _, annotation := range p.RequiredAnnotations {
+		if rule.Annotations[annotation] == "" {
+			violations = append(violations, fmt.Sprintf("missing annotation %q", annotation))
+		}
+	}
+	if severity, ok := rule.Labels[severityLabel]; ok && len(p.AllowedSeverities) != 0 && !utils.OneOf(severity, p.AllowedSeverities) {
+		violations = append(violations, fmt.Sprintf("severity %q is not one of %q", severity, p.AllowedSeverities))
+	}
+	if rule.For < time.Duration(p.MinFor) {
+		violations = append(violations, fmt.Sprintf("for duration %v is shorter than %v", model.Duration(rule.For), p.MinFor))
+	}
+	for _, pattern := range p.ForbiddenPatterns {
+		if pattern.regexp == nil || !pattern.regexp.MatchString(rule.Expr) {
+			continue
+		}
+		violation := fmt.Sprintf("expression matches forbidden pattern %q", pattern.Pattern)
+		if pattern.Message != "" {
+			violation = fmt.Sprintf("%v: %v", violation, pattern.Message)
+		}
+		violations = append(violations, violation)
+	}
+	return violations
+}
+
+// severityLabel is the alert label with the alert severity.
+const severityLabel = "severity"
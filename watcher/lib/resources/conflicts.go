@@ -0,0 +1,151 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+
+	"github.com/gravitational/rigging"
+	"github.com/gravitational/trace"
+	v1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/prometheus/prometheus/promql/parser"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// CheckConflicts checks the rules that are about to be created in the
+// PrometheusRule with the specified name against all existing PrometheusRules,
+// including the ones shipped with the monitoring chart.
+//
+// An error is returned if the PrometheusRule already exists and has been
+// created from a different resource. Alerting or recording rules defined
+// elsewhere with the same name but a different expression, as well as
+// groups with the same name, are returned as warnings.
+func (c *Client) CheckConflicts(name string, groups []RuleGroup, owner *metav1.OwnerReference) (warnings []string, err error) {
+	rules, err := c.Rules.List(c.Context, metav1.ListOptions{})
+	if err != nil {
+		return nil, trace.Wrap(rigging.ConvertError(err))
+	}
+	sort.Slice(rules.Items, func(i, j int) bool {
+		return rules.Items[i].Name < rules.Items[j].Name
+	})
+	for _, rule := range rules.Items {
+		if rule.Name == name {
+			if err := checkRuleOwner(rule, owner); err != nil {
+				return nil, trace.Wrap(err)
+			}
+			continue
+		}
+		warnings = append(warnings, ruleConflicts(rule, groups)...)
+	}
+	return warnings, nil
+}
+
+// checkRuleOwner returns an error if the existing PrometheusRule has not
+// been created from the specified resource.
+func checkRuleOwner(rule *v1.PrometheusRule, owner *metav1.OwnerReference) error {
+	if !isManagedRule(rule) {
+		return trace.AlreadyExists("PrometheusRule %v already exists and is not managed by the watcher", rule.Name)
+	}
+	if owner == nil {
+		return nil
+	}
+	for _, existing := range rule.OwnerReferences {
+		// Rules owned by a ConfigMap that has been recreated with the
+		// same name are taken over by the new ConfigMap.
+		if existing.Kind == owner.Kind && existing.Name != owner.Name {
+			return trace.AlreadyExists("PrometheusRule %v is already defined by %v %v",
+				rule.Name, existing.Kind, existing.Name)
+		}
+	}
+	return nil
+}
+
+// isManagedRule returns true if the PrometheusRule has been created by the
+// watcher, including rules created by versions that did not mark them as
+// managed and only had the default rule labels.
+func isManagedRule(rule *v1.PrometheusRule) bool {
+	if rule.Labels[constants.ManagedByLabel] == constants.ManagedByWatcher {
+		return true
+	}
+	for k, v := range defaultRuleLabels {
+		if rule.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ruleConflicts returns the conflicts between the provided groups and
+// the rules of the existing PrometheusRule.
+func ruleConflicts(rule *v1.PrometheusRule, groups []RuleGroup) (conflicts []string) {
+	existingGroups := make(map[string]struct{})
+	// existingExprs maps names of existing alerting and recording rules
+	// to their expressions.
+	existingExprs := make(map[string][]string)
+	for _, group := range rule.Spec.Groups {
+		existingGroups[group.Name] = struct{}{}
+		for _, existing := range group.Rules {
+			key := ruleKey(existing.Record, existing.Alert, existing.Labels)
+			existingExprs[key] = append(existingExprs[key], normalizeExpr(existing.Expr.String()))
+		}
+	}
+	for _, group := range groups {
+		if _, ok := existingGroups[group.Name]; ok {
+			conflicts = append(conflicts, fmt.Sprintf("group %q is also defined in PrometheusRule %v",
+				group.Name, rule.Name))
+		}
+		for _, r := range group.Rules {
+			exprs, ok := existingExprs[ruleKey(r.Record, r.Alert, r.Labels)]
+			if !ok {
+				continue
+			}
+			expr := normalizeExpr(r.Expr)
+			for _, existing := range exprs {
+				if existing != expr {
+					conflicts = append(conflicts, fmt.Sprintf("rule %q is also defined in PrometheusRule %v with a different expression %q",
+						r.name(), rule.Name, existing))
+					break
+				}
+			}
+		}
+	}
+	return conflicts
+}
+
+// ruleKey returns the key identifying alerting and recording rules by name
+// and labels. Rules with the same name but different labels produce
+// different series so they do not conflict.
+func ruleKey(record, alert string, ruleLabels map[string]string) string {
+	if record != "" {
+		return fmt.Sprintf("record:%v{%v}", record, labels.Set(ruleLabels))
+	}
+	return fmt.Sprintf("alert:%v{%v}", alert, labels.Set(ruleLabels))
+}
+
+// normalizeExpr returns the expression in canonical form so expressions that
+// only differ in formatting are considered equal.
+func normalizeExpr(expr string) string {
+	parsed, err := parser.ParseExpr(expr)
+	if err != nil {
+		return expr
+	}
+	return parsed.String()
+}
@@ -21,11 +21,16 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+
+	"github.com/ghodss/yaml"
 	"github.com/gravitational/rigging"
 	"github.com/gravitational/trace"
 	v1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
 	monitoring "github.com/prometheus-operator/prometheus-operator/pkg/client/versioned"
 	monitoringv1 "github.com/prometheus-operator/prometheus-operator/pkg/client/versioned/typed/monitoring/v1"
+	commoncfg "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
 	"github.com/sirupsen/logrus"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -43,10 +48,33 @@ type Resources interface {
 	UpsertAlertTarget(AlertTarget) error
 	// DeleteAlertTarget resets monitoring alerts recipient.
 	DeleteAlertTarget() error
+	// GetAlertTargets returns configured recipients of monitoring alerts.
+	GetAlertTargets() ([]AlertTarget, error)
+	// RouteAlert returns the receivers the alert with the provided labels
+	// is routed to by the Alertmanager configuration.
+	RouteAlert(labels map[string]string) ([]AlertRoute, error)
 	// UpsertAlert creates a new or updates an existing monitoring alert.
 	UpsertAlert(Alert) error
 	// DeleteAlert deletes specified monitoring alert.
 	DeleteAlert(name string) error
+	// UpsertRecordingRules creates new or updates existing recording rules.
+	UpsertRecordingRules(RecordingRules) error
+	// DeleteRecordingRules deletes specified recording rules.
+	DeleteRecordingRules(name string) error
+	// UpsertSLO creates new or updates existing rules implementing the SLO.
+	UpsertSLO(SLO) error
+	// DeleteSLO deletes rules implementing the specified SLO.
+	DeleteSLO(name string) error
+	// GetManagedRules returns PrometheusRules created by the watcher.
+	GetManagedRules() ([]ManagedRule, error)
+	// DeleteManagedRule deletes the specified PrometheusRule created by the watcher.
+	DeleteManagedRule(name string) error
+	// CheckRuleSelector warns about PrometheusRules not selected by Prometheus.
+	CheckRuleSelector() error
+	// CheckConflicts checks the rules about to be created against existing
+	// PrometheusRules. It returns an error if the rules can't be created
+	// and warnings about conflicting definitions otherwise.
+	CheckConflicts(name string, groups []RuleGroup, owner *metav1.OwnerReference) ([]string, error)
 }
 
 // Client is Prometheus-based monitoring resource manager.
@@ -57,8 +85,14 @@ type Client struct {
 	Secrets corev1.SecretInterface
 	// Rules is the Kubernetes PrometheusRules CRD client.
 	Rules monitoringv1.PrometheusRuleInterface
+	// Prometheuses is the Kubernetes Prometheus CRD client.
+	Prometheuses monitoringv1.PrometheusInterface
+	// Namespaces is the Kubernetes Namespaces client.
+	Namespaces corev1.NamespaceInterface
 	// Namespace is the monitoring namespace.
 	Namespace string
+	// ruleLabels caches the labels PrometheusRules are marked with.
+	ruleLabelsCache ruleLabelsCache
 	// FieldLogger provides logging facilities.
 	logrus.FieldLogger
 	context.Context
@@ -96,11 +130,13 @@ func New(ctx context.Context, conf ClientConfig) (*Client, error) {
 		return nil, trace.Wrap(err)
 	}
 	return &Client{
-		Secrets:     conf.KubernetesClient.CoreV1().Secrets(conf.Namespace),
-		Rules:       conf.MonitoringClient.MonitoringV1().PrometheusRules(conf.Namespace),
-		Namespace:   conf.Namespace,
-		FieldLogger: logrus.WithField(trace.Component, "resources"),
-		Context:     ctx,
+		Secrets:      conf.KubernetesClient.CoreV1().Secrets(conf.Namespace),
+		Rules:        conf.MonitoringClient.MonitoringV1().PrometheusRules(conf.Namespace),
+		Prometheuses: conf.MonitoringClient.MonitoringV1().Prometheuses(constants.MonitoringNamespace),
+		Namespaces:   conf.KubernetesClient.CoreV1().Namespaces(),
+		Namespace:    conf.Namespace,
+		FieldLogger:  logrus.WithField(trace.Component, "resources"),
+		Context:      ctx,
 	}, nil
 }
 
@@ -114,11 +150,34 @@ type SMTPConfig struct {
 	Username string
 	// Password is the SMTP user password.
 	Password string
+	// From is the sender address used in alert notifications.
+	From string
+	// Hello is the hostname sent in the SMTP EHLO/HELO command.
+	Hello string
+	// RequireTLS enforces STARTTLS when set. If nil, Alertmanager
+	// defaults apply.
+	RequireTLS *bool
+	// AuthIdentity is the identity used for PLAIN authentication.
+	AuthIdentity string
+	// AuthSecret is the secret used for CRAM-MD5 authentication.
+	AuthSecret string
+	// TLS is the TLS configuration used to connect to the SMTP server.
+	TLS commoncfg.TLSConfig
+}
+
+// Addr returns the SMTP smarthost address in host:port format.
+func (c SMTPConfig) Addr() string {
+	if c.Host == "" {
+		return ""
+	}
+	return fmt.Sprintf("%v:%v", c.Host, c.Port)
 }
 
 // String returns the config's string representation.
 func (c SMTPConfig) String() string {
-	return fmt.Sprintf("SMTP(Host=%v,Port=%v,Username=%v)", c.Host, c.Port, c.Username)
+	return fmt.Sprintf("SMTP(Host=%v,Port=%v,Username=%v,From=%v,Hello=%v,AuthIdentity=%v,ServerName=%v,InsecureSkipVerify=%v)",
+		c.Host, c.Port, c.Username, c.From, c.Hello, c.AuthIdentity,
+		c.TLS.ServerName, c.TLS.InsecureSkipVerify)
 }
 
 // AlertTarget represents a recipient of monitoring alerts.
@@ -133,9 +192,14 @@ func (t AlertTarget) String() string {
 }
 
 // Alert represents a monitoring alert.
+//
+// An alert is either described by a list of rule groups or, in its legacy
+// form, by a single alerting rule.
 type Alert struct {
 	// CRDName is the name of PrometheusRule custom resource.
 	CRDName string
+	// Groups is the list of alerting rule groups.
+	Groups []RuleGroup
 	// AlertName is the alerting rule name.
 	AlertName string
 	// GroupName is group name the alert belongs to.
@@ -148,14 +212,119 @@ type Alert struct {
 	Labels map[string]string
 	// Annotations are used to attach longer information to the alert.
 	Annotations map[string]string
+	// Owner optionally references the resource the alert has been created from.
+	Owner *metav1.OwnerReference
 }
 
 // String returns the alert's string representation.
 func (a Alert) String() string {
+	if len(a.Groups) != 0 {
+		return fmt.Sprintf("Alert(CRDName=%v,Groups=%v)", a.CRDName, a.Groups)
+	}
 	return fmt.Sprintf("Alert(CRDName=%v,AlertName=%v,GroupName=%v,Formula=%v,Delay=%v,Labels=%v)",
 		a.CRDName, a.AlertName, a.GroupName, a.Formula, a.Delay, a.Labels)
 }
 
+// RuleGroups returns the alert's rule groups. The legacy single rule form
+// is converted to a single group with a single rule.
+func (a Alert) RuleGroups() []RuleGroup {
+	if len(a.Groups) != 0 {
+		return a.Groups
+	}
+	groupName := a.GroupName
+	if groupName == "" {
+		groupName = fmt.Sprintf("%v.rules", a.CRDName)
+	}
+	alertName := a.AlertName
+	if alertName == "" {
+		alertName = a.CRDName
+	}
+	return []RuleGroup{{
+		Name: groupName,
+		Rules: []Rule{{
+			Alert:       alertName,
+			Expr:        a.Formula,
+			For:         a.Delay,
+			Labels:      a.Labels,
+			Annotations: a.Annotations,
+		}},
+	}}
+}
+
+// RuleGroup is a group of rules evaluated together.
+type RuleGroup struct {
+	// Name is the group name.
+	Name string
+	// Interval is an optional group evaluation interval.
+	Interval time.Duration
+	// PartialResponseStrategy is an optional Thanos partial response strategy.
+	PartialResponseStrategy string
+	// Rules is the list of rules in the group.
+	Rules []Rule
+}
+
+// String returns the group's string representation.
+func (g RuleGroup) String() string {
+	return fmt.Sprintf("RuleGroup(Name=%v,Interval=%v,Rules=%v)", g.Name, g.Interval, g.Rules)
+}
+
+// Rule is an alerting or recording rule.
+type Rule struct {
+	// Record is the name of the time series to record the result to.
+	// Only set for recording rules.
+	Record string
+	// Alert is the alert name. Only set for alerting rules.
+	Alert string
+	// Expr is the rule expression.
+	Expr string
+	// For is an optional duration the expression has to hold before the alert fires.
+	For time.Duration
+	// Labels is the labels that get attached to the alert.
+	Labels map[string]string
+	// Annotations are used to attach longer information to the alert.
+	Annotations map[string]string
+}
+
+// String returns the rule's string representation.
+func (r Rule) String() string {
+	if r.Record != "" {
+		return fmt.Sprintf("Rule(Record=%v,Expr=%v,Labels=%v)", r.Record, r.Expr, r.Labels)
+	}
+	return fmt.Sprintf("Rule(Alert=%v,Expr=%v,For=%v,Labels=%v)", r.Alert, r.Expr, r.For, r.Labels)
+}
+
+// name returns the rule's name for error reporting.
+func (r Rule) name() string {
+	if r.Record != "" {
+		return r.Record
+	}
+	return r.Alert
+}
+
+// RecordingRules represents a set of recording rules.
+type RecordingRules struct {
+	// CRDName is the name of PrometheusRule custom resource.
+	CRDName string
+	// Groups is the list of recording rule groups.
+	Groups []RuleGroup
+	// Owner optionally references the resource the rules have been created from.
+	Owner *metav1.OwnerReference
+}
+
+// String returns the recording rules string representation.
+func (r RecordingRules) String() string {
+	return fmt.Sprintf("RecordingRules(CRDName=%v,Groups=%v)", r.CRDName, r.Groups)
+}
+
+// ManagedRule is a PrometheusRule created by the watcher.
+type ManagedRule struct {
+	// Name is the name of PrometheusRule custom resource.
+	Name string
+	// Owner references the ConfigMap the rule has been created from.
+	// It is not set for rules created by previous watcher versions.
+	Owner *metav1.OwnerReference
+}
+
 // UpsertSMTPConfig updates cluster SMTP configuration.
 func (c *Client) UpsertSMTPConfig(smtpConf SMTPConfig) error {
 	c.Infof("Updating SMTP configuration: %s.", smtpConf)
@@ -163,8 +332,7 @@ func (c *Client) UpsertSMTPConfig(smtpConf SMTPConfig) error {
 	if err != nil {
 		return trace.Wrap(err)
 	}
-	err = updateSMTPConfig(conf, fmt.Sprintf("%v:%v", smtpConf.Host, smtpConf.Port),
-		smtpConf.Username, smtpConf.Password)
+	err = updateSMTPConfig(conf, smtpConf)
 	if err != nil {
 		return trace.Wrap(err)
 	}
@@ -205,10 +373,13 @@ func (c *Client) UpsertAlertTarget(alertTarget AlertTarget) error {
 	if err != nil {
 		return trace.Wrap(err)
 	}
-	// Update its email config.
-	defaultReceiver.EmailConfigs = []*EmailConfig{
-		{To: alertTarget.Email},
+	// Update its email config preserving SMTP settings of the existing one.
+	emailConfig := &EmailConfig{}
+	if len(defaultReceiver.EmailConfigs) != 0 {
+		*emailConfig = *defaultReceiver.EmailConfigs[0]
 	}
+	emailConfig.To = alertTarget.Email
+	defaultReceiver.EmailConfigs = []*EmailConfig{emailConfig}
 	err = c.updateAlertmanagerConfig(conf)
 	if err != nil {
 		return trace.Wrap(err)
@@ -237,10 +408,79 @@ func (c *Client) DeleteAlertTarget() error {
 	return nil
 }
 
+// GetAlertTargets returns configured recipients of monitoring alerts.
+func (c *Client) GetAlertTargets() ([]AlertTarget, error) {
+	conf, err := c.getAlertmanagerConfig()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	defaultReceiver, err := getDefaultReceiver(conf)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var targets []AlertTarget
+	for _, emailConfig := range defaultReceiver.EmailConfigs {
+		if emailConfig.To != "" {
+			targets = append(targets, AlertTarget{Email: emailConfig.To})
+		}
+	}
+	return targets, nil
+}
+
 // UpsertAlert creates a new or updates an existing monitoring alert.
 func (c *Client) UpsertAlert(alert Alert) error {
 	c.Infof("Creating alert: %s.", alert)
-	_, err := c.Rules.Create(c.Context, c.newPrometheusRule(alert), metav1.CreateOptions{})
+	return c.upsertPrometheusRule(c.newPrometheusRule(alert.CRDName, alert.RuleGroups(), alert.Owner))
+}
+
+// DeleteAlert deletes specified monitoring alert.
+func (c *Client) DeleteAlert(name string) error {
+	c.Infof("Deleting alert: %v.", name)
+	return c.deletePrometheusRule(name)
+}
+
+// UpsertRecordingRules creates new or updates existing recording rules.
+func (c *Client) UpsertRecordingRules(rules RecordingRules) error {
+	c.Infof("Creating recording rules: %s.", rules)
+	return c.upsertPrometheusRule(c.newPrometheusRule(rules.CRDName, rules.Groups, rules.Owner))
+}
+
+// DeleteRecordingRules deletes specified recording rules.
+func (c *Client) DeleteRecordingRules(name string) error {
+	c.Infof("Deleting recording rules: %v.", name)
+	return c.deletePrometheusRule(name)
+}
+
+// GetManagedRules returns PrometheusRules created by the watcher.
+func (c *Client) GetManagedRules() ([]ManagedRule, error) {
+	rules, err := c.Rules.List(c.Context, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%v=%v", constants.ManagedByLabel, constants.ManagedByWatcher),
+	})
+	if err != nil {
+		return nil, trace.Wrap(rigging.ConvertError(err))
+	}
+	var result []ManagedRule
+	for _, rule := range rules.Items {
+		managedRule := ManagedRule{Name: rule.Name}
+		for i, owner := range rule.OwnerReferences {
+			if owner.Kind == kindConfigMap {
+				managedRule.Owner = &rule.OwnerReferences[i]
+			}
+		}
+		result = append(result, managedRule)
+	}
+	return result, nil
+}
+
+// DeleteManagedRule deletes the specified PrometheusRule created by the watcher.
+func (c *Client) DeleteManagedRule(name string) error {
+	c.Infof("Deleting orphaned rules: %v.", name)
+	return c.deletePrometheusRule(name)
+}
+
+// upsertPrometheusRule creates a new or updates an existing PrometheusRule.
+func (c *Client) upsertPrometheusRule(newRule *v1.PrometheusRule) error {
+	_, err := c.Rules.Create(c.Context, newRule, metav1.CreateOptions{})
 	if err == nil {
 		return nil
 	}
@@ -250,11 +490,11 @@ func (c *Client) UpsertAlert(alert Alert) error {
 	}
 	// Updating PrometheusRule requires resourceVersion to be set on the
 	// CRD object so retrieve it first and update appropriate fields.
-	rule, err := c.Rules.Get(c.Context, alert.CRDName, metav1.GetOptions{})
+	rule, err := c.Rules.Get(c.Context, newRule.Name, metav1.GetOptions{})
 	if err != nil {
 		return trace.Wrap(rigging.ConvertError(err))
 	}
-	c.updatePrometheusRule(rule, alert)
+	c.updatePrometheusRule(rule, newRule)
 	_, err = c.Rules.Update(c.Context, rule, metav1.UpdateOptions{})
 	if err != nil {
 		return trace.Wrap(rigging.ConvertError(err))
@@ -262,9 +502,8 @@ func (c *Client) UpsertAlert(alert Alert) error {
 	return nil
 }
 
-// DeleteAlert deletes specified monitoring alert.
-func (c *Client) DeleteAlert(name string) error {
-	c.Infof("Deleting alert: %v.", name)
+// deletePrometheusRule deletes the specified PrometheusRule.
+func (c *Client) deletePrometheusRule(name string) error {
 	err := c.Rules.Delete(c.Context, name, metav1.DeleteOptions{})
 	if err != nil {
 		return trace.Wrap(rigging.ConvertError(err))
@@ -291,7 +530,9 @@ func (c *Client) getAlertmanagerConfig() (*Config, error) {
 
 // updateAlertmanagerConfig updates Alertmanager configuration.
 func (c *Client) updateAlertmanagerConfig(conf *Config) error {
-	c.Debugf("Updating alertmanager configuration file: %#v.", conf)
+	// Do not log the configuration as it may contain credentials.
+	c.Debug("Updating alertmanager configuration file.")
+	removeTestAlertRoute(conf)
 	secret, err := c.Secrets.Get(c.Context, alertmanagerSecretName, metav1.GetOptions{})
 	if err != nil {
 		return trace.Wrap(rigging.ConvertError(err))
@@ -321,49 +562,72 @@ func getDefaultReceiver(conf *Config) (*Receiver, error) {
 }
 
 // updateSMTPConfig updates SMTP configuration in the provided config.
-func updateSMTPConfig(conf *Config, addr, user, pass string) error {
-	conf.Global.SMTPSmarthost = addr
-	conf.Global.SMTPAuthUsername = user
-	conf.Global.SMTPAuthPassword = pass
+func updateSMTPConfig(conf *Config, smtpConf SMTPConfig) error {
+	if conf.Global == nil {
+		conf.Global = &GlobalConfig{}
+	}
+	// The sender address is only defaulted when SMTP is configured so
+	// deleting the configuration clears it.
+	from := smtpConf.From
+	if from == "" && smtpConf.Host != "" {
+		from = constants.AlertFrom
+	}
+	conf.Global.SMTPSmarthost = smtpConf.Addr()
+	conf.Global.SMTPFrom = from
+	conf.Global.SMTPHello = smtpConf.Hello
+	// Unset TLS requirement falls back to the Alertmanager default.
+	conf.Global.SMTPRequireTLS = smtpConf.RequireTLS
+	conf.Global.SMTPAuthUsername = smtpConf.Username
+	conf.Global.SMTPAuthPassword = smtpConf.Password
+	conf.Global.SMTPAuthIdentity = smtpConf.AuthIdentity
+	conf.Global.SMTPAuthSecret = smtpConf.AuthSecret
 	// Update SMTP config on the default receiver too.
 	defaultReceiver, err := getDefaultReceiver(conf)
 	if err != nil {
 		return trace.Wrap(err)
 	}
 	for _, emailConfig := range defaultReceiver.EmailConfigs {
-		emailConfig.Smarthost = addr
-		emailConfig.AuthUsername = user
-		emailConfig.AuthPassword = pass
+		emailConfig.Smarthost = smtpConf.Addr()
+		emailConfig.From = from
+		emailConfig.Hello = smtpConf.Hello
+		emailConfig.RequireTLS = smtpConf.RequireTLS
+		emailConfig.AuthUsername = smtpConf.Username
+		emailConfig.AuthPassword = smtpConf.Password
+		emailConfig.AuthIdentity = smtpConf.AuthIdentity
+		emailConfig.AuthSecret = smtpConf.AuthSecret
+		emailConfig.TLSConfig = smtpConf.TLS
 	}
 	return nil
 }
 
 // deleteSMTPConfig resets SMTP configuration in the provided config.
 func deleteSMTPConfig(conf *Config) error {
-	return updateSMTPConfig(conf, "", "", "")
+	return updateSMTPConfig(conf, SMTPConfig{})
 }
 
 // updatePrometheusRule updates the provided PrometheusRule spec based on
-// the new alert data.
-func (c *Client) updatePrometheusRule(rule *v1.PrometheusRule, alert Alert) {
-	rule.Spec = c.newPrometheusRule(alert).Spec
-}
-
-// newPrometheusRule returns PrometheusRule CRD object for the provided alert.
-func (c *Client) newPrometheusRule(alert Alert) *v1.PrometheusRule {
-	groupName := alert.GroupName
-	if groupName == "" {
-		groupName = fmt.Sprintf("%v.rules", alert.CRDName)
+// the new rule data.
+func (c *Client) updatePrometheusRule(rule, newRule *v1.PrometheusRule) {
+	if rule.Labels == nil {
+		rule.Labels = make(map[string]string)
 	}
-	alertName := alert.AlertName
-	if alertName == "" {
-		alertName = alert.CRDName
+	for k, v := range newRule.Labels {
+		rule.Labels[k] = v
 	}
-	rule := v1.Rule{
-		Alert:       alertName,
-		Expr:        intstr.FromString(alert.Formula),
-		Labels:      alert.Labels,
-		Annotations: alert.Annotations,
+	rule.OwnerReferences = newRule.OwnerReferences
+	rule.Spec = newRule.Spec
+}
+
+// newPrometheusRule returns PrometheusRule CRD object with the provided rule
+// groups, optionally owned by the specified resource.
+//
+// The rule is labeled to match the rule selector of Prometheus.
+func (c *Client) newPrometheusRule(name string, ruleGroups []RuleGroup, owner *metav1.OwnerReference) *v1.PrometheusRule {
+	labels := c.ruleLabels()
+	labels[constants.ManagedByLabel] = constants.ManagedByWatcher
+	var owners []metav1.OwnerReference
+	if owner != nil {
+		owners = append(owners, *owner)
 	}
 	return &v1.PrometheusRule{
 		TypeMeta: metav1.TypeMeta{
@@ -371,26 +635,74 @@ func (c *Client) newPrometheusRule(alert Alert) *v1.PrometheusRule {
 			APIVersion: v1.SchemeGroupVersion.String(),
 		},
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      alert.CRDName,
-			Namespace: c.Namespace,
-			Labels:    prometheusRuleLabels,
-		},
-		Spec: v1.PrometheusRuleSpec{
-			Groups: []v1.RuleGroup{{
-				Name:  groupName,
-				Rules: []v1.Rule{rule},
-			}},
+			Name:            name,
+			Namespace:       c.Namespace,
+			Labels:          labels,
+			OwnerReferences: owners,
 		},
+		Spec: newPrometheusRuleSpec(ruleGroups),
 	}
 }
 
-// prometheusRuleLabels is the labels that PrometheusRule CRD should be marked
-// with in order to be recognized by Prometheus operator controller.
-var prometheusRuleLabels = map[string]string{
-	"prometheus": "k8s",
-	"role":       "alert-rules",
+// newPrometheusRuleSpec returns PrometheusRule CRD spec with the provided rule groups.
+func newPrometheusRuleSpec(ruleGroups []RuleGroup) v1.PrometheusRuleSpec {
+	var groups []v1.RuleGroup
+	for _, group := range ruleGroups {
+		groups = append(groups, newRuleGroup(group))
+	}
+	return v1.PrometheusRuleSpec{
+		Groups: groups,
+	}
 }
 
+// RuleFile returns the Prometheus rule file Prometheus operator generates
+// from the PrometheusRule with the provided rule groups.
+func RuleFile(ruleGroups []RuleGroup) ([]byte, error) {
+	spec := newPrometheusRuleSpec(ruleGroups)
+	// Partial response strategy is only supported by Thanos ruler
+	// and is removed by the operator for Prometheus.
+	for i := range spec.Groups {
+		spec.Groups[i].PartialResponseStrategy = ""
+	}
+	data, err := yaml.Marshal(spec)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return data, nil
+}
+
+// newRuleGroup returns PrometheusRule rule group for the provided group.
+func newRuleGroup(group RuleGroup) v1.RuleGroup {
+	ruleGroup := v1.RuleGroup{
+		Name:                    group.Name,
+		Interval:                formatDuration(group.Interval),
+		PartialResponseStrategy: group.PartialResponseStrategy,
+	}
+	for _, rule := range group.Rules {
+		ruleGroup.Rules = append(ruleGroup.Rules, v1.Rule{
+			Record:      rule.Record,
+			Alert:       rule.Alert,
+			Expr:        intstr.FromString(rule.Expr),
+			For:         formatDuration(rule.For),
+			Labels:      rule.Labels,
+			Annotations: rule.Annotations,
+		})
+	}
+	return ruleGroup
+}
+
+// formatDuration formats the duration in Prometheus format or returns
+// an empty string if it's not set.
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+	return model.Duration(d).String()
+}
+
+// kindConfigMap is the kind of ConfigMap resources.
+var kindConfigMap = "ConfigMap"
+
 // alertmanagerConfigFilename is the name of Alertmanager configuration file.
 var alertmanagerConfigFilename = "alertmanager.yaml"
 
@@ -0,0 +1,196 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"regexp"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+
+	"github.com/gravitational/trace"
+)
+
+// AlertRoute is a receiver an alert is routed to.
+type AlertRoute struct {
+	// Receiver is the receiver name.
+	Receiver string
+	// Integrations is the names of the receiver notification integrations
+	// as used in Alertmanager notification metrics, such as email or webhook.
+	Integrations []string
+	// GroupBy is the labels the matched route groups alerts by.
+	GroupBy []string
+}
+
+// RouteAlert returns the receivers the alert with the provided labels is
+// routed to by the Alertmanager configuration.
+func (c *Client) RouteAlert(labels map[string]string) ([]AlertRoute, error) {
+	conf, err := c.getAlertmanagerConfig()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return routeAlert(conf, labels)
+}
+
+// routeAlert returns the receivers the provided config routes the alert with
+// the specified labels to.
+func routeAlert(conf *Config, labels map[string]string) ([]AlertRoute, error) {
+	if conf.Route == nil {
+		return nil, trace.NotFound("no Alertmanager route")
+	}
+	var routes []AlertRoute
+	for _, route := range matchRoutes(*conf.Route, labels) {
+		receiver, err := getReceiver(conf, route.Receiver)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		routes = append(routes, AlertRoute{
+			Receiver:     receiver.Name,
+			Integrations: receiver.integrations(),
+			GroupBy:      route.GroupByStr,
+		})
+	}
+	return routes, nil
+}
+
+// matchRoutes returns the routes of the provided route tree that match the
+// labels the same way Alertmanager matches them: the first matching child
+// route is followed unless it continues matching and the route itself is
+// returned if none of its children match.
+//
+// Child routes inherit the receiver and grouping of their parent.
+func matchRoutes(route Route, labels map[string]string) []Route {
+	if !routeMatches(route, labels) {
+		return nil
+	}
+	var matches []Route
+	for _, child := range route.Routes {
+		inherited := *child
+		if inherited.Receiver == "" {
+			inherited.Receiver = route.Receiver
+		}
+		if inherited.GroupByStr == nil {
+			inherited.GroupByStr = route.GroupByStr
+		}
+		childMatches := matchRoutes(inherited, labels)
+		matches = append(matches, childMatches...)
+		if len(childMatches) != 0 && !child.Continue {
+			break
+		}
+	}
+	if len(matches) == 0 {
+		return []Route{route}
+	}
+	return matches
+}
+
+// routeMatches returns true if the labels match all matchers of the route.
+// Regular expressions are anchored like in Alertmanager.
+func routeMatches(route Route, labels map[string]string) bool {
+	for name, value := range route.Match {
+		if labels[name] != value {
+			return false
+		}
+	}
+	for name, re := range route.MatchRE {
+		if re.Regexp == nil {
+			return false
+		}
+		matched, err := regexp.MatchString("^(?:"+re.String()+")$", labels[name])
+		if err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// getReceiver returns the receiver with the specified name from the config.
+func getReceiver(conf *Config, name string) (*Receiver, error) {
+	for _, r := range conf.Receivers {
+		if r.Name == name {
+			return r, nil
+		}
+	}
+	return nil, trace.NotFound("no receiver %q", name)
+}
+
+// integrations returns the names of the receiver notification integrations
+// as used in Alertmanager notification metrics.
+func (r Receiver) integrations() (integrations []string) {
+	for _, integration := range []struct {
+		name    string
+		configs int
+	}{
+		{name: integrationEmail, configs: len(r.EmailConfigs)},
+		{name: integrationPagerduty, configs: len(r.PagerdutyConfigs)},
+		{name: integrationHipchat, configs: len(r.HipchatConfigs)},
+		{name: integrationSlack, configs: len(r.SlackConfigs)},
+		{name: integrationWebhook, configs: len(r.WebhookConfigs)},
+		{name: integrationOpsGenie, configs: len(r.OpsGenieConfigs)},
+		{name: integrationWechat, configs: len(r.WechatConfigs)},
+		{name: integrationPushover, configs: len(r.PushoverConfigs)},
+		{name: integrationVictorOps, configs: len(r.VictorOpsConfigs)},
+	} {
+		if integration.configs != 0 {
+			integrations = append(integrations, integration.name)
+		}
+	}
+	return integrations
+}
+
+// removeTestAlertRoute removes the dedicated test alert receiver and its
+// route added by older versions of the watcher so the test alert goes through
+// the same routes as other alerts.
+func removeTestAlertRoute(conf *Config) {
+	var receivers []*Receiver
+	for _, r := range conf.Receivers {
+		if r.Name != constants.TestAlertReceiver {
+			receivers = append(receivers, r)
+		}
+	}
+	conf.Receivers = receivers
+	if conf.Route == nil {
+		return
+	}
+	var routes []*Route
+	for _, route := range conf.Route.Routes {
+		if route.Receiver != constants.TestAlertReceiver {
+			routes = append(routes, route)
+		}
+	}
+	conf.Route.Routes = routes
+}
+
+const (
+	// integrationEmail is the name of the email integration.
+	integrationEmail = "email"
+	// integrationPagerduty is the name of the PagerDuty integration.
+	integrationPagerduty = "pagerduty"
+	// integrationHipchat is the name of the HipChat integration.
+	integrationHipchat = "hipchat"
+	// integrationSlack is the name of the Slack integration.
+	integrationSlack = "slack"
+	// integrationWebhook is the name of the webhook integration.
+	integrationWebhook = "webhook"
+	// integrationOpsGenie is the name of the OpsGenie integration.
+	integrationOpsGenie = "opsgenie"
+	// integrationWechat is the name of the WeChat integration.
+	integrationWechat = "wechat"
+	// integrationPushover is the name of the Pushover integration.
+	integrationPushover = "pushover"
+	// integrationVictorOps is the name of the VictorOps integration.
+	integrationVictorOps = "victorops"
+)
@@ -0,0 +1,193 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+
+	"github.com/gravitational/rigging"
+	"github.com/gravitational/trace"
+	v1 "github.com/prometheus-operator/prometheus-operator/pkg/apis/monitoring/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// CheckRuleSelector verifies that PrometheusRules in the monitoring namespace
+// are selected by Prometheus and logs a warning for every rule that is not.
+func (c *Client) CheckRuleSelector() error {
+	prometheus, err := c.getPrometheus()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if err := c.checkRuleNamespace(prometheus); err != nil {
+		c.WithError(err).Warn("PrometheusRules will not be loaded by Prometheus.")
+	}
+	selector, err := metav1.LabelSelectorAsSelector(prometheus.Spec.RuleSelector)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	rules, err := c.Rules.List(c.Context, metav1.ListOptions{})
+	if err != nil {
+		return trace.Wrap(rigging.ConvertError(err))
+	}
+	for _, rule := range rules.Items {
+		if !selector.Matches(labels.Set(rule.Labels)) {
+			c.Warnf("PrometheusRule %v with labels %v does not match rule selector %q of Prometheus %v and will not be loaded.",
+				rule.Name, rule.Labels, selector, prometheus.Name)
+		}
+	}
+	return nil
+}
+
+// ruleLabels returns the labels PrometheusRules should be marked with in
+// order to be selected by Prometheus.
+//
+// The labels are derived from the Prometheus rule selector and cached for
+// ruleLabelsTTL. If Prometheus can't be queried, the previously derived
+// labels or, if there are none, the default labels are used.
+func (c *Client) ruleLabels() map[string]string {
+	cache := &c.ruleLabelsCache
+	cache.Lock()
+	defer cache.Unlock()
+	if cache.labels != nil && time.Now().Before(cache.expires) {
+		return copyLabels(cache.labels)
+	}
+	cache.expires = time.Now().Add(ruleLabelsTTL)
+	prometheus, err := c.getPrometheus()
+	if err != nil {
+		if cache.labels == nil {
+			c.WithError(err).Warn("Failed to query Prometheus rule selector, using default PrometheusRule labels.")
+			cache.labels = defaultRuleLabels
+		} else {
+			c.WithError(err).Warn("Failed to query Prometheus rule selector, using previous PrometheusRule labels.")
+		}
+		return copyLabels(cache.labels)
+	}
+	ruleLabels := selectorLabels(prometheus.Spec.RuleSelector)
+	selector, err := metav1.LabelSelectorAsSelector(prometheus.Spec.RuleSelector)
+	if err != nil {
+		c.WithError(err).Warnf("Invalid rule selector of Prometheus %v, using default PrometheusRule labels.", prometheus.Name)
+		ruleLabels = defaultRuleLabels
+	} else if !selector.Matches(labels.Set(ruleLabels)) {
+		c.Warnf("Failed to derive labels matching rule selector %q of Prometheus %v, PrometheusRules will not be loaded.",
+			selector, prometheus.Name)
+	}
+	cache.labels = ruleLabels
+	return copyLabels(cache.labels)
+}
+
+// ruleLabelsCache caches the labels derived from the Prometheus rule selector.
+type ruleLabelsCache struct {
+	sync.Mutex
+	// labels is the cached labels
+	labels map[string]string
+	// expires is the time the labels are derived again after
+	expires time.Time
+}
+
+// copyLabels returns a copy of the provided labels.
+func copyLabels(in map[string]string) map[string]string {
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+// checkRuleNamespace returns an error if PrometheusRules in the monitoring
+// namespace are not selected by the provided Prometheus rule namespace selector.
+func (c *Client) checkRuleNamespace(prometheus *v1.Prometheus) error {
+	// Prometheus only selects rules from its own namespace if
+	// the namespace selector is not set.
+	if prometheus.Spec.RuleNamespaceSelector == nil {
+		if prometheus.Namespace != c.Namespace {
+			return trace.BadParameter("Prometheus %v only selects rules from namespace %v",
+				prometheus.Name, prometheus.Namespace)
+		}
+		return nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(prometheus.Spec.RuleNamespaceSelector)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	if selector.Empty() {
+		return nil
+	}
+	namespace, err := c.Namespaces.Get(c.Context, c.Namespace, metav1.GetOptions{})
+	if err != nil {
+		c.WithError(err).Debugf("Failed to verify rule namespace selector %q.", selector)
+		return nil
+	}
+	if !selector.Matches(labels.Set(namespace.Labels)) {
+		return trace.BadParameter("namespace %v does not match rule namespace selector %q of Prometheus %v",
+			c.Namespace, selector, prometheus.Name)
+	}
+	return nil
+}
+
+// getPrometheus returns the Prometheus CRD object of the monitoring stack.
+func (c *Client) getPrometheus() (*v1.Prometheus, error) {
+	prometheus, err := c.Prometheuses.Get(c.Context, constants.PrometheusName, metav1.GetOptions{})
+	if err != nil {
+		return nil, trace.Wrap(rigging.ConvertError(err))
+	}
+	return prometheus, nil
+}
+
+// selectorLabels returns the labels that satisfy the provided label selector
+// as far as it is possible.
+//
+// A nil selector does not select anything so no labels are returned for it.
+func selectorLabels(selector *metav1.LabelSelector) map[string]string {
+	result := make(map[string]string)
+	if selector == nil {
+		return result
+	}
+	for k, v := range selector.MatchLabels {
+		result[k] = v
+	}
+	for _, expr := range selector.MatchExpressions {
+		switch expr.Operator {
+		case metav1.LabelSelectorOpIn:
+			if _, ok := result[expr.Key]; !ok && len(expr.Values) != 0 {
+				result[expr.Key] = expr.Values[0]
+			}
+		case metav1.LabelSelectorOpExists:
+			if _, ok := result[expr.Key]; !ok {
+				result[expr.Key] = existsLabelValue
+			}
+		}
+	}
+	return result
+}
+
+const (
+	// existsLabelValue is the value of labels required to exist by selectors.
+	existsLabelValue = "true"
+	// ruleLabelsTTL is how long the labels derived from the Prometheus rule selector are cached.
+	ruleLabelsTTL = 5 * time.Minute
+)
+
+// defaultRuleLabels is the labels PrometheusRules are marked with if the
+// Prometheus rule selector can't be queried.
+var defaultRuleLabels = map[string]string{
+	"prometheus": "k8s",
+	"role":       "alert-rules",
+}
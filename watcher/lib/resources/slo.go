@@ -0,0 +1,277 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+
+	"github.com/gravitational/trace"
+	"github.com/prometheus/common/model"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SLO represents a service level objective.
+//
+// The SLO is implemented with the multi-window, multi-burn-rate alerts
+// described in the Google SRE workbook and the recording rules they use.
+type SLO struct {
+	// CRDName is the name of PrometheusRule custom resource. It is also
+	// the value of the slo label on the generated series and alerts.
+	CRDName string
+	// Good is the expression that returns the rate of good events.
+	Good string
+	// Total is the expression that returns the rate of all events.
+	Total string
+	// Objective is the percentage of good events, e.g. 99.9.
+	Objective float64
+	// Window is the period the objective is defined for.
+	Window time.Duration
+	// PageSeverity is the severity of alerts for fast error budget burn.
+	PageSeverity string
+	// TicketSeverity is the severity of alerts for slow error budget burn.
+	TicketSeverity string
+	// Labels is the labels that get attached to the alerts.
+	Labels map[string]string
+	// Annotations are attached to the alerts in addition to the generated ones.
+	Annotations map[string]string
+	// Owner optionally references the resource the SLO has been created from.
+	Owner *metav1.OwnerReference
+}
+
+// String returns the SLO's string representation.
+func (s SLO) String() string {
+	return fmt.Sprintf("SLO(CRDName=%v,Good=%v,Total=%v,Objective=%v,Window=%v)",
+		s.CRDName, s.Good, s.Total, s.Objective, model.Duration(s.Window))
+}
+
+// Check makes sure the SLO is valid and its rules would be accepted by Prometheus.
+func (s SLO) Check() error {
+	if s.Objective <= 0 || s.Objective >= 100 {
+		return trace.BadParameter("objective must be between 0 and 100, got %v", s.Objective)
+	}
+	if longest := sloBurnRates[len(sloBurnRates)-1].longWindow; s.Window <= longest {
+		return trace.BadParameter("window must be longer than %v, got %v",
+			model.Duration(longest), model.Duration(s.Window))
+	}
+	for _, expr := range []string{s.Good, s.Total} {
+		short, err := renderSLI(expr, sloWindows[0])
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		long, err := renderSLI(expr, sloWindows[len(sloWindows)-1])
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		if short == long {
+			return trace.BadParameter("expression %q must use the {{.window}} placeholder as the range", expr)
+		}
+	}
+	groups, err := s.RuleGroups()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return checkRuleGroups(groups)
+}
+
+// RuleGroups returns the recording and alerting rules implementing the SLO.
+func (s SLO) RuleGroups() ([]RuleGroup, error) {
+	errorBudget := formatRatio((100 - s.Objective) / 100)
+	sliGroup := RuleGroup{Name: fmt.Sprintf("slo-%v-sli", s.CRDName)}
+	for _, window := range sloWindows {
+		expr, err := s.errorRatioExpr(window)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		sliGroup.Rules = append(sliGroup.Rules, Rule{
+			Record: SLOErrorRatioRecord(window),
+			Expr:   expr,
+			Labels: map[string]string{constants.SLOLabel: s.CRDName},
+		})
+	}
+
+	selector := fmt.Sprintf("{%v=%q}", constants.SLOLabel, s.CRDName)
+	shortest := SLOErrorRatioRecord(sloWindows[0]) + selector
+	metaGroup := RuleGroup{
+		Name: fmt.Sprintf("slo-%v-meta", s.CRDName),
+		Rules: []Rule{
+			{
+				Record: "slo:objective:ratio",
+				Expr:   formatRatio(s.Objective / 100),
+			},
+			{
+				Record: "slo:error_budget:ratio",
+				Expr:   errorBudget,
+			},
+			{
+				Record: "slo:time_period:days",
+				Expr:   formatRatio(s.Window.Hours() / 24),
+			},
+			{
+				Record: SLOErrorRatioRecord(s.Window),
+				Expr: fmt.Sprintf("sum_over_time(%v[%v]) / count_over_time(%v[%v])",
+					shortest, model.Duration(s.Window), shortest, model.Duration(s.Window)),
+			},
+			{
+				Record: "slo:period_error_budget_remaining:ratio",
+				Expr:   fmt.Sprintf("1 - %v%v / %v", SLOErrorRatioRecord(s.Window), selector, errorBudget),
+			},
+		},
+	}
+	for i := range metaGroup.Rules {
+		metaGroup.Rules[i].Labels = map[string]string{constants.SLOLabel: s.CRDName}
+	}
+
+	alertGroup := RuleGroup{Name: fmt.Sprintf("slo-%v-alerts", s.CRDName)}
+	for _, severity := range []struct {
+		severity  string
+		burnRates []sloBurnRate
+	}{
+		{severity: s.PageSeverity, burnRates: sloBurnRates[:2]},
+		{severity: s.TicketSeverity, burnRates: sloBurnRates[2:]},
+	} {
+		var conditions []string
+		for _, burnRate := range severity.burnRates {
+			threshold := fmt.Sprintf("(%v * %v)", formatRatio(burnRate.factor(s.Window)), errorBudget)
+			conditions = append(conditions, fmt.Sprintf("(%v%v > %v and %v%v > %v)",
+				SLOErrorRatioRecord(burnRate.longWindow), selector, threshold,
+				SLOErrorRatioRecord(burnRate.shortWindow), selector, threshold))
+		}
+		labels := map[string]string{
+			constants.SLOLabel: s.CRDName,
+			"severity":         severity.severity,
+			"slo_objective":    formatRatio(s.Objective),
+		}
+		for k, v := range s.Labels {
+			labels[k] = v
+		}
+		annotations := map[string]string{
+			"summary": fmt.Sprintf("SLO %v is burning its error budget too fast.", s.CRDName),
+			"description": fmt.Sprintf("SLO %v error rate is {{ $value | humanizePercentage }} "+
+				"which exhausts the error budget of the %v%% objective over %v too early.",
+				s.CRDName, formatRatio(s.Objective), model.Duration(s.Window)),
+		}
+		for k, v := range s.Annotations {
+			annotations[k] = v
+		}
+		alertGroup.Rules = append(alertGroup.Rules, Rule{
+			Alert:       sloAlertName,
+			Expr:        strings.Join(conditions, "\nor\n"),
+			Labels:      labels,
+			Annotations: annotations,
+		})
+	}
+	return []RuleGroup{sliGroup, metaGroup, alertGroup}, nil
+}
+
+// errorRatioExpr returns the expression that computes the ratio of bad
+// events over the specified window.
+func (s SLO) errorRatioExpr(window time.Duration) (string, error) {
+	good, err := renderSLI(s.Good, window)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	total, err := renderSLI(s.Total, window)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	return fmt.Sprintf("1 - ((%v) / (%v))", good, total), nil
+}
+
+// renderSLI replaces the window placeholder in the SLI expression.
+func renderSLI(expr string, window time.Duration) (string, error) {
+	tmpl, err := template.New("sli").Option("missingkey=error").Parse(expr)
+	if err != nil {
+		return "", trace.BadParameter("invalid expression %q: %v", expr, err)
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, map[string]string{"window": model.Duration(window).String()})
+	if err != nil {
+		return "", trace.BadParameter("invalid expression %q: %v", expr, err)
+	}
+	return buf.String(), nil
+}
+
+// UpsertSLO creates new or updates existing rules implementing the SLO.
+func (c *Client) UpsertSLO(slo SLO) error {
+	c.Infof("Creating SLO: %s.", slo)
+	groups, err := slo.RuleGroups()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return c.upsertPrometheusRule(c.newPrometheusRule(slo.CRDName, groups, slo.Owner))
+}
+
+// DeleteSLO deletes rules implementing the specified SLO.
+func (c *Client) DeleteSLO(name string) error {
+	c.Infof("Deleting SLO: %v.", name)
+	return c.deletePrometheusRule(name)
+}
+
+// sloBurnRate defines an error budget burn rate alert condition.
+type sloBurnRate struct {
+	// budgetConsumed is the share of error budget consumed over the long window.
+	budgetConsumed float64
+	// longWindow is the window the burn rate is computed over.
+	longWindow time.Duration
+	// shortWindow is the window used to make sure the budget is still being burnt.
+	shortWindow time.Duration
+}
+
+// factor returns the burn rate relative to the SLO window.
+func (r sloBurnRate) factor(window time.Duration) float64 {
+	return r.budgetConsumed * float64(window) / float64(r.longWindow)
+}
+
+// SLOErrorRatioRecord returns the name of the series with the SLO error
+// ratio over the specified window.
+func SLOErrorRatioRecord(window time.Duration) string {
+	return fmt.Sprintf("slo:sli_error:ratio_rate%v", model.Duration(window))
+}
+
+// formatRatio formats the number without floating point noise.
+func formatRatio(value float64) string {
+	return strconv.FormatFloat(value, 'g', 10, 64)
+}
+
+var (
+	// sloBurnRates is the list of burn rate alert conditions. The first two
+	// conditions page, the last two create tickets.
+	sloBurnRates = []sloBurnRate{
+		{budgetConsumed: 0.02, longWindow: time.Hour, shortWindow: 5 * time.Minute},
+		{budgetConsumed: 0.05, longWindow: 6 * time.Hour, shortWindow: 30 * time.Minute},
+		{budgetConsumed: 0.1, longWindow: 24 * time.Hour, shortWindow: 2 * time.Hour},
+		{budgetConsumed: 0.1, longWindow: 72 * time.Hour, shortWindow: 6 * time.Hour},
+	}
+
+	// sloWindows is the list of windows SLO error ratios are recorded for.
+	sloWindows = []time.Duration{
+		5 * time.Minute, 30 * time.Minute, time.Hour, 2 * time.Hour,
+		6 * time.Hour, 24 * time.Hour, 72 * time.Hour,
+	}
+)
+
+const (
+	// sloAlertName is the name of error budget burn alerts.
+	sloAlertName = "SLOErrorBudgetBurn"
+)
@@ -0,0 +1,203 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+
+	"github.com/gravitational/trace"
+)
+
+// CheckSMTPConfig opens an SMTP session to the smarthost from the provided
+// configuration and verifies that it accepts the configured TLS settings and
+// credentials the same way Alertmanager would use them.
+//
+// If any recipients are provided, a test message is sent to each of them.
+//
+// Credentials are never sent over a TLS session with an unverified server
+// certificate. If the certificate cannot be verified, authentication and the
+// test message are skipped and authSkipped is set.
+func CheckSMTPConfig(ctx context.Context, conf SMTPConfig, recipients ...string) (authSkipped bool, err error) {
+	if conf.Host == "" {
+		return false, trace.BadParameter("missing SMTP host")
+	}
+	ctx, cancel := context.WithTimeout(ctx, smtpCheckTimeout)
+	defer cancel()
+
+	tlsConfig := smtpTLSConfig(conf)
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", conf.Addr())
+	if err != nil {
+		return false, trace.ConnectionProblem(err, "failed to connect to %v", conf.Addr())
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Port 465 expects TLS from the start of the session and does not
+	// support STARTTLS, same as in Alertmanager.
+	if conf.Port == smtpsPort {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, conf.Host)
+	if err != nil {
+		conn.Close()
+		return false, trace.ConnectionProblem(err, "failed to start SMTP session with %v", conf.Addr())
+	}
+	defer client.Close()
+
+	hello := conf.Hello
+	if hello == "" {
+		hello = "localhost"
+	}
+	if err := client.Hello(hello); err != nil {
+		return false, trace.Wrap(err, "EHLO failed")
+	}
+
+	if conf.Port != smtpsPort {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return false, trace.Wrap(err, "STARTTLS failed")
+			}
+		} else if conf.RequireTLS != nil && *conf.RequireTLS {
+			return false, trace.BadParameter("TLS is required but %v does not advertise STARTTLS", conf.Addr())
+		}
+	}
+
+	if conf.Username != "" && tlsConfig.InsecureSkipVerify {
+		// The server might not be the one the credentials are meant for.
+		if err := client.Quit(); err != nil {
+			return true, trace.Wrap(err, "QUIT failed")
+		}
+		return true, nil
+	}
+
+	if ok, mechs := client.Extension("AUTH"); ok {
+		auth, err := smtpAuth(conf, mechs)
+		if err != nil {
+			return false, trace.Wrap(err)
+		}
+		if auth != nil {
+			if err := client.Auth(auth); err != nil {
+				return false, trace.AccessDenied("AUTH failed: %v", err)
+			}
+		}
+	} else if conf.Username != "" {
+		return false, trace.BadParameter("credentials are configured but %v does not advertise AUTH", conf.Addr())
+	}
+
+	if len(recipients) != 0 {
+		if err := sendTestMessage(client, conf, recipients); err != nil {
+			return false, trace.Wrap(err)
+		}
+	}
+
+	if err := client.Quit(); err != nil {
+		return false, trace.Wrap(err, "QUIT failed")
+	}
+	return false, nil
+}
+
+// smtpTLSConfig returns the TLS configuration for the check.
+//
+// The CA, certificate and key files are paths in the Alertmanager pod that do not
+// exist where the check runs, so the server certificate cannot be verified if a CA
+// file is configured and the client certificate is not presented.
+func smtpTLSConfig(conf SMTPConfig) *tls.Config {
+	tlsConfig := &tls.Config{
+		ServerName:         conf.TLS.ServerName,
+		InsecureSkipVerify: conf.TLS.InsecureSkipVerify || conf.TLS.CAFile != "",
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = conf.Host
+	}
+	return tlsConfig
+}
+
+// smtpAuth returns the authentication mechanism to use with the server
+// based on the provided configuration and the mechanisms the server supports.
+func smtpAuth(conf SMTPConfig, mechs string) (smtp.Auth, error) {
+	if conf.Username == "" {
+		return nil, nil
+	}
+	for _, mech := range strings.Split(mechs, " ") {
+		switch mech {
+		case "CRAM-MD5":
+			if conf.AuthSecret == "" {
+				continue
+			}
+			return smtp.CRAMMD5Auth(conf.Username, conf.AuthSecret), nil
+		case "PLAIN":
+			if conf.Password == "" {
+				continue
+			}
+			return smtp.PlainAuth(conf.AuthIdentity, conf.Username, conf.Password, conf.Host), nil
+		}
+	}
+	return nil, trace.BadParameter("no supported authentication mechanism among %q", mechs)
+}
+
+// sendTestMessage sends a test message to the provided recipients over the
+// established SMTP session.
+func sendTestMessage(client *smtp.Client, conf SMTPConfig, recipients []string) error {
+	from := conf.From
+	if from == "" {
+		from = constants.AlertFrom
+	}
+	if err := client.Mail(from); err != nil {
+		return trace.Wrap(err, "MAIL FROM failed")
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return trace.Wrap(err, "RCPT TO %v failed", recipient)
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return trace.Wrap(err, "DATA failed")
+	}
+	message := fmt.Sprintf("From: %v\r\nTo: %v\r\nSubject: %v\r\nDate: %v\r\n\r\n%v\r\n",
+		from, strings.Join(recipients, ", "), smtpTestSubject,
+		time.Now().Format(time.RFC1123Z), smtpTestBody)
+	if _, err := w.Write([]byte(message)); err != nil {
+		return trace.Wrap(err)
+	}
+	if err := w.Close(); err != nil {
+		return trace.Wrap(err, "failed to send test message")
+	}
+	return nil
+}
+
+const (
+	// smtpsPort is the port of SMTP over implicit TLS.
+	smtpsPort = 465
+	// smtpCheckTimeout is the maximum amount of time the SMTP check can take.
+	smtpCheckTimeout = 30 * time.Second
+	// smtpTestSubject is the subject of the test message.
+	smtpTestSubject = "Test message from cluster monitoring"
+	// smtpTestBody is the body of the test message.
+	smtpTestBody = "This is a test message sent to verify the SMTP configuration of cluster alerts."
+)
@@ -0,0 +1,170 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"github.com/gravitational/trace"
+	commoncfg "github.com/prometheus/common/config"
+)
+
+func TestCheckSMTPConfig(t *testing.T) {
+	requireTLS := true
+	tests := []struct {
+		comment     string
+		conf        SMTPConfig
+		check       func(error) bool
+		authSkipped bool
+	}{
+		{
+			comment: "credentials are accepted and the test message is sent",
+			conf:    SMTPConfig{Username: "alertmanager", Password: "secret"},
+			check:   func(err error) bool { return err == nil },
+		},
+		{
+			comment: "credentials are not sent if the server certificate cannot be verified",
+			conf: SMTPConfig{Username: "alertmanager", Password: "wrong",
+				TLS: commoncfg.TLSConfig{CAFile: "/etc/alertmanager/secrets/smtp/ca.crt"}},
+			check:       func(err error) bool { return err == nil },
+			authSkipped: true,
+		},
+		{
+			comment: "credentials are rejected",
+			conf:    SMTPConfig{Username: "alertmanager", Password: "wrong"},
+			check:   trace.IsAccessDenied,
+		},
+		{
+			comment: "TLS is required but STARTTLS is not advertised",
+			conf:    SMTPConfig{RequireTLS: &requireTLS},
+			check:   trace.IsBadParameter,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.comment, func(t *testing.T) {
+			server := newSMTPStub(t, "alertmanager", "secret")
+			conf := tt.conf
+			conf.Host, conf.Port = server.addr()
+
+			authSkipped, err := CheckSMTPConfig(context.Background(), conf, "ops@example.com")
+			if !tt.check(err) {
+				t.Fatalf("unexpected result: %v", err)
+			}
+			if authSkipped != tt.authSkipped {
+				t.Fatalf("expected authSkipped to be %v", tt.authSkipped)
+			}
+			expected := 1
+			if err != nil || authSkipped {
+				expected = 0
+			}
+			if server.messages() != expected {
+				t.Fatalf("expected %v test messages, got %v", expected, server.messages())
+			}
+		})
+	}
+}
+
+// smtpStub is an SMTP server that accepts PLAIN authentication with the
+// configured credentials and does not advertise STARTTLS.
+type smtpStub struct {
+	listener net.Listener
+	username string
+	password string
+	received chan struct{}
+}
+
+// newSMTPStub starts the SMTP stub listening on the loopback interface.
+func newSMTPStub(t *testing.T, username, password string) *smtpStub {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &smtpStub{
+		listener: listener,
+		username: username,
+		password: password,
+		received: make(chan struct{}, 1),
+	}
+	t.Cleanup(func() { listener.Close() })
+	go server.serve()
+	return server
+}
+
+// addr returns the host and port the stub listens on.
+func (s *smtpStub) addr() (string, int) {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+// messages returns the number of messages received so far.
+func (s *smtpStub) messages() int {
+	return len(s.received)
+}
+
+func (s *smtpStub) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(textproto.NewConn(conn))
+	}
+}
+
+func (s *smtpStub) handle(conn *textproto.Conn) {
+	defer conn.Close()
+	conn.PrintfLine("220 localhost ESMTP stub")
+	for {
+		line, err := conn.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO":
+			conn.PrintfLine("250-localhost")
+			conn.PrintfLine("250 AUTH PLAIN")
+		case "AUTH":
+			if line == "AUTH PLAIN "+base64.StdEncoding.EncodeToString([]byte(
+				fmt.Sprintf("\x00%v\x00%v", s.username, s.password))) {
+				conn.PrintfLine("235 Authentication successful")
+			} else {
+				conn.PrintfLine("535 Authentication failed")
+			}
+		case "MAIL", "RCPT":
+			conn.PrintfLine("250 OK")
+		case "DATA":
+			conn.PrintfLine("354 Go ahead")
+			if _, err := conn.ReadDotBytes(); err != nil {
+				return
+			}
+			s.received <- struct{}{}
+			conn.PrintfLine("250 OK")
+		case "QUIT":
+			conn.PrintfLine("221 Bye")
+			return
+		default:
+			conn.PrintfLine("502 Command not implemented")
+		}
+	}
+}
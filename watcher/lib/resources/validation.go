@@ -0,0 +1,52 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"github.com/gravitational/trace"
+	"github.com/prometheus/prometheus/pkg/rulefmt"
+)
+
+// Check makes sure the alert's rules would be accepted by Prometheus.
+//
+// Prometheus refuses to load a rule file if any of its rules is invalid,
+// so a single broken rule would take down every other rule in the same file.
+func (a Alert) Check() error {
+	return trace.Wrap(checkRuleGroups(a.RuleGroups()))
+}
+
+// Check makes sure the recording rules would be accepted by Prometheus.
+func (r RecordingRules) Check() error {
+	return trace.Wrap(checkRuleGroups(r.Groups))
+}
+
+// checkRuleGroups validates the rule file generated for the provided rule
+// groups the same way Prometheus validates rule files when loading them,
+// including parsing of expressions and alert templates.
+//
+// Line numbers in the errors refer to the generated rule file.
+func checkRuleGroups(groups []RuleGroup) error {
+	data, err := RuleFile(groups)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	_, errors := rulefmt.Parse(data)
+	for i, err := range errors {
+		errors[i] = trace.BadParameter(err.Error())
+	}
+	return trace.NewAggregate(errors...)
+}
@@ -0,0 +1,637 @@
+// Copyright 2018 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rulestest implements offline unit testing of alerting and recording
+// rules. Test files follow the format of promtool rule unit tests, except that
+// rule files are converted into Prometheus rule files by the provided function
+// before they are loaded into the embedded rule engine.
+package rulestest
+
+// This file contains the rule unit test runner adapted from promtool:
+//
+// https://github.com/prometheus/prometheus/blob/v2.28.1/cmd/promtool/unittest.go
+//
+// with the following modifications:
+//
+// * rule files are converted by the configured function before loading,
+// * errors are reported with trace and results are written to the configured output,
+// * input series are loaded by seriesLoader, which is adapted from promql.LazyLoader
+//   (https://github.com/prometheus/prometheus/blob/v2.28.1/promql/test.go), instead of
+//   promql.LazyLoader itself, so the test-only dependencies of the promql test
+//   framework are not compiled into the watcher.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gravitational/trace"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the rules test configuration.
+type Config struct {
+	// RuleFiles converts a rule source file referenced by a test file
+	// into one or more Prometheus rule files.
+	RuleFiles func(filename string) ([][]byte, error)
+	// Out is where test results are written to.
+	Out io.Writer
+}
+
+// CheckAndSetDefaults validates the config and sets default values.
+func (c *Config) CheckAndSetDefaults() error {
+	if c.RuleFiles == nil {
+		return trace.BadParameter("missing RuleFiles")
+	}
+	if c.Out == nil {
+		c.Out = os.Stdout
+	}
+	return nil
+}
+
+// Run runs the unit tests from the provided test files and writes
+// results to the configured output. It returns an error if any test fails.
+func Run(config Config, files ...string) error {
+	if err := config.CheckAndSetDefaults(); err != nil {
+		return trace.Wrap(err)
+	}
+	var failed int
+	for _, file := range files {
+		fmt.Fprintln(config.Out, "Unit Testing:", file)
+		if errs := runFile(config, file); len(errs) != 0 {
+			fmt.Fprintln(config.Out, "  FAILED:")
+			for _, err := range errs {
+				fmt.Fprintln(config.Out, err.Error())
+			}
+			failed++
+		} else {
+			fmt.Fprintln(config.Out, "  SUCCESS")
+		}
+		fmt.Fprintln(config.Out)
+	}
+	if failed != 0 {
+		return trace.CompareFailed("%v of %v test files failed", failed, len(files))
+	}
+	return nil
+}
+
+// runFile runs the unit tests from the specified test file.
+func runFile(config Config, filename string) []error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return []error{trace.ConvertSystemError(err)}
+	}
+
+	var file testFile
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return []error{trace.Wrap(err)}
+	}
+	if file.EvaluationInterval == 0 {
+		file.EvaluationInterval = model.Duration(defaultEvaluationInterval)
+	}
+
+	dir, err := ioutil.TempDir("", "rulestest")
+	if err != nil {
+		return []error{trace.ConvertSystemError(err)}
+	}
+	defer os.RemoveAll(dir)
+
+	ruleFiles, err := writeRuleFiles(config, filepath.Dir(filename), dir, file.RuleFiles)
+	if err != nil {
+		return []error{trace.Wrap(err)}
+	}
+
+	// Groups with lower numbers are evaluated before groups with higher numbers.
+	groupOrder := make(map[string]int)
+	for i, name := range file.GroupEvalOrder {
+		if _, ok := groupOrder[name]; ok {
+			return []error{trace.BadParameter("group name repeated in evaluation order: %v", name)}
+		}
+		groupOrder[name] = i
+	}
+
+	var errs []error
+	for _, test := range file.Tests {
+		errs = append(errs, test.run(time.Duration(file.EvaluationInterval), groupOrder, ruleFiles...)...)
+	}
+	return errs
+}
+
+// writeRuleFiles resolves rule files referenced by a test file relative to
+// the test file directory, converts them to Prometheus rule files and writes
+// the results to the provided directory.
+func writeRuleFiles(config Config, baseDir, dir string, patterns []string) (ruleFiles []string, err error) {
+	for _, pattern := range patterns {
+		if pattern != "" && !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(baseDir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if len(matches) == 0 {
+			fmt.Fprintln(config.Out, "  WARNING: no file match pattern", pattern)
+		}
+		for _, match := range matches {
+			contents, err := config.RuleFiles(match)
+			if err != nil {
+				return nil, trace.Wrap(err, "failed to convert %v", match)
+			}
+			for _, data := range contents {
+				path := filepath.Join(dir, fmt.Sprintf("%v.yaml", len(ruleFiles)))
+				if err := ioutil.WriteFile(path, data, 0600); err != nil {
+					return nil, trace.ConvertSystemError(err)
+				}
+				ruleFiles = append(ruleFiles, path)
+			}
+		}
+	}
+	return ruleFiles, nil
+}
+
+// testFile is the contents of a single unit test file.
+type testFile struct {
+	// RuleFiles is the list of rule source files to test.
+	RuleFiles []string `yaml:"rule_files"`
+	// EvaluationInterval is the interval rules are evaluated at.
+	EvaluationInterval model.Duration `yaml:"evaluation_interval,omitempty"`
+	// GroupEvalOrder is the order rule groups are evaluated in.
+	GroupEvalOrder []string `yaml:"group_eval_order"`
+	// Tests is the list of test groups.
+	Tests []testGroup `yaml:"tests"`
+}
+
+// testGroup is a group of input series and tests associated with it.
+type testGroup struct {
+	Interval        model.Duration   `yaml:"interval"`
+	InputSeries     []series         `yaml:"input_series"`
+	AlertRuleTests  []alertTestCase  `yaml:"alert_rule_test,omitempty"`
+	PromqlExprTests []promqlTestCase `yaml:"promql_expr_test,omitempty"`
+	ExternalLabels  labels.Labels    `yaml:"external_labels,omitempty"`
+	ExternalURL     string           `yaml:"external_url,omitempty"`
+	TestGroupName   string           `yaml:"name,omitempty"`
+}
+
+// run loads the rule files into the embedded rule engine, evaluates them
+// against the input series and compares the results with expectations.
+func (tg *testGroup) run(evalInterval time.Duration, groupOrder map[string]int, ruleFiles ...string) []error {
+	suite, err := newSeriesLoader(time.Duration(tg.Interval), tg.InputSeries, evalInterval)
+	if err != nil {
+		return []error{trace.Wrap(err)}
+	}
+	defer suite.Close()
+
+	manager := rules.NewManager(&rules.ManagerOptions{
+		QueryFunc:  rules.EngineQueryFunc(suite.engine, suite.db),
+		Appendable: suite.db,
+		Context:    context.Background(),
+		NotifyFunc: func(ctx context.Context, expr string, alerts ...*rules.Alert) {},
+		Logger:     log.NewNopLogger(),
+	})
+	groupsMap, errs := manager.LoadGroups(time.Duration(tg.Interval), tg.ExternalLabels, tg.ExternalURL, ruleFiles...)
+	if errs != nil {
+		return errs
+	}
+	groups := orderedGroups(groupsMap, groupOrder)
+
+	mint := time.Unix(0, 0).UTC()
+	maxt := mint.Add(tg.maxEvalTime())
+
+	// Alerts are checked as rules are evaluated so they don't need to be
+	// stored for every evaluation.
+	alertEvalTimesMap := map[model.Duration]struct{}{}
+	alertsInTest := make(map[model.Duration]map[string]struct{})
+	alertTests := make(map[model.Duration][]alertTestCase)
+	for _, alert := range tg.AlertRuleTests {
+		if alert.Alertname == "" {
+			return []error{trace.BadParameter("an item under alert_rule_test misses required attribute alertname at eval_time %v%v",
+				alert.EvalTime, tg.nameSuffix())}
+		}
+		alertEvalTimesMap[alert.EvalTime] = struct{}{}
+		if _, ok := alertsInTest[alert.EvalTime]; !ok {
+			alertsInTest[alert.EvalTime] = make(map[string]struct{})
+		}
+		alertsInTest[alert.EvalTime][alert.Alertname] = struct{}{}
+		alertTests[alert.EvalTime] = append(alertTests[alert.EvalTime], alert)
+	}
+	alertEvalTimes := make([]model.Duration, 0, len(alertEvalTimesMap))
+	for evalTime := range alertEvalTimesMap {
+		alertEvalTimes = append(alertEvalTimes, evalTime)
+	}
+	sort.Slice(alertEvalTimes, func(i, j int) bool {
+		return alertEvalTimes[i] < alertEvalTimes[j]
+	})
+
+	for _, group := range groups {
+		for _, rule := range group.Rules() {
+			if alertRule, ok := rule.(*rules.AlertingRule); ok {
+				// Mark alerting rules as restored so the ALERTS time series
+				// is created when they run.
+				alertRule.SetRestored(true)
+			}
+		}
+	}
+
+	// curr is the index of the next alert evaluation time to check.
+	curr := 0
+	for ts := mint; !ts.After(maxt); ts = ts.Add(evalInterval) {
+		var evalErrs []error
+		if err := suite.appendTill(ts); err != nil {
+			errs = append(errs, err)
+		} else {
+			for _, group := range groups {
+				group.Eval(context.Background(), ts)
+				for _, rule := range group.Rules() {
+					if rule.LastError() != nil {
+						evalErrs = append(evalErrs, trace.BadParameter("    rule: %v, time: %v, err: %v",
+							rule.Name(), ts.Sub(mint), rule.LastError()))
+					}
+				}
+			}
+		}
+		errs = append(errs, evalErrs...)
+		// Stop only if the rules failed to evaluate, test failures
+		// are collected and reported together.
+		if len(evalErrs) != 0 {
+			return errs
+		}
+
+		// Alerts expected at eval_time are compared with the results of the
+		// evaluation at ts if ts <= eval_time < ts+evalInterval.
+		for curr < len(alertEvalTimes) && ts.Sub(mint) <= time.Duration(alertEvalTimes[curr]) &&
+			time.Duration(alertEvalTimes[curr]) < ts.Add(evalInterval).Sub(mint) {
+			evalTime := alertEvalTimes[curr]
+			got := firingAlerts(groups, alertsInTest[evalTime])
+			for _, testCase := range alertTests[evalTime] {
+				if err := testCase.compare(got[testCase.Alertname], tg.TestGroupName); err != nil {
+					errs = append(errs, err)
+				}
+			}
+			curr++
+		}
+	}
+
+	for _, testCase := range tg.PromqlExprTests {
+		if err := testCase.run(suite, mint); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// firingAlerts returns the firing alerts of the provided alerting rules.
+// The same alert can be defined in multiple groups so all of them are collected.
+func firingAlerts(groups []*rules.Group, names map[string]struct{}) map[string]labelsAndAnnotations {
+	got := make(map[string]labelsAndAnnotations)
+	for _, group := range groups {
+		for _, rule := range group.Rules() {
+			alertRule, ok := rule.(*rules.AlertingRule)
+			if !ok {
+				continue
+			}
+			if _, ok := names[alertRule.Name()]; !ok {
+				continue
+			}
+			var alerts labelsAndAnnotations
+			for _, alert := range alertRule.ActiveAlerts() {
+				if alert.State == rules.StateFiring {
+					alerts = append(alerts, labelAndAnnotation{
+						Labels:      append(labels.Labels{}, alert.Labels...),
+						Annotations: append(labels.Labels{}, alert.Annotations...),
+					})
+				}
+			}
+			got[alertRule.Name()] = append(got[alertRule.Name()], alerts...)
+		}
+	}
+	return got
+}
+
+// maxEvalTime returns the max eval time among all alert and PromQL tests.
+func (tg *testGroup) maxEvalTime() time.Duration {
+	var max model.Duration
+	for _, alert := range tg.AlertRuleTests {
+		if alert.EvalTime > max {
+			max = alert.EvalTime
+		}
+	}
+	for _, expr := range tg.PromqlExprTests {
+		if expr.EvalTime > max {
+			max = expr.EvalTime
+		}
+	}
+	return time.Duration(max)
+}
+
+// nameSuffix returns the test group name suffix for error messages.
+func (tg *testGroup) nameSuffix() string {
+	if tg.TestGroupName == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (in TestGroup %v)", tg.TestGroupName)
+}
+
+// orderedGroups returns the groups in the order specified by groupOrder.
+// Groups missing from groupOrder are evaluated first.
+func orderedGroups(groupsMap map[string]*rules.Group, groupOrder map[string]int) []*rules.Group {
+	groups := make([]*rules.Group, 0, len(groupsMap))
+	for _, group := range groupsMap {
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groupOrder[groups[i].Name()] < groupOrder[groups[j].Name()]
+	})
+	return groups
+}
+
+type series struct {
+	Series string `yaml:"series"`
+	Values string `yaml:"values"`
+}
+
+type alertTestCase struct {
+	EvalTime  model.Duration `yaml:"eval_time"`
+	Alertname string         `yaml:"alertname"`
+	ExpAlerts []alert        `yaml:"exp_alerts"`
+}
+
+type alert struct {
+	ExpLabels      map[string]string `yaml:"exp_labels"`
+	ExpAnnotations map[string]string `yaml:"exp_annotations"`
+}
+
+// compare returns an error if the firing alerts don't match the expected ones.
+func (tc alertTestCase) compare(gotAlerts labelsAndAnnotations, groupName string) error {
+	var expAlerts labelsAndAnnotations
+	for _, alert := range tc.ExpAlerts {
+		// Alert name label is added by Prometheus during evaluation
+		// so it is not specified in the test.
+		expLabels := labels.FromMap(alert.ExpLabels)
+		expLabels = append(expLabels, labels.Label{Name: labels.AlertName, Value: tc.Alertname})
+		sort.Sort(expLabels)
+		expAlerts = append(expAlerts, labelAndAnnotation{
+			Labels:      expLabels,
+			Annotations: labels.FromMap(alert.ExpAnnotations),
+		})
+	}
+	sort.Sort(gotAlerts)
+	sort.Sort(expAlerts)
+	if gotAlerts.Len() == expAlerts.Len() && reflect.DeepEqual(expAlerts, gotAlerts) {
+		return nil
+	}
+	var sb strings.Builder
+	if groupName != "" {
+		fmt.Fprintf(&sb, "    name: %v,\n", groupName)
+	}
+	fmt.Fprintf(&sb, "    alertname: %v, time: %v,\n", tc.Alertname, tc.EvalTime)
+	fmt.Fprintf(&sb, "        exp: %v,\n", expAlerts)
+	fmt.Fprintf(&sb, "        got: %v", gotAlerts)
+	return trace.CompareFailed("%v", sb.String())
+}
+
+type promqlTestCase struct {
+	Expr       string         `yaml:"expr"`
+	EvalTime   model.Duration `yaml:"eval_time"`
+	ExpSamples []sample       `yaml:"exp_samples"`
+}
+
+// run evaluates the expression and compares the result with expected samples.
+func (tc promqlTestCase) run(suite *seriesLoader, mint time.Time) error {
+	got, err := query(context.Background(), tc.Expr, mint.Add(time.Duration(tc.EvalTime)),
+		suite.engine, suite.db)
+	if err != nil {
+		return trace.BadParameter("    expr: %q, time: %v, err: %v", tc.Expr, tc.EvalTime, err)
+	}
+
+	var gotSamples []parsedSample
+	for _, s := range got {
+		gotSamples = append(gotSamples, parsedSample{
+			Labels: s.Metric.Copy(),
+			Value:  s.V,
+		})
+	}
+
+	var expSamples []parsedSample
+	for _, s := range tc.ExpSamples {
+		lb, err := parser.ParseMetric(s.Labels)
+		if err != nil {
+			return trace.BadParameter("    expr: %q, time: %v, err: labels %q: %v", tc.Expr, tc.EvalTime, s.Labels, err)
+		}
+		expSamples = append(expSamples, parsedSample{
+			Labels: lb,
+			Value:  s.Value,
+		})
+	}
+
+	sort.Slice(expSamples, func(i, j int) bool {
+		return labels.Compare(expSamples[i].Labels, expSamples[j].Labels) <= 0
+	})
+	sort.Slice(gotSamples, func(i, j int) bool {
+		return labels.Compare(gotSamples[i].Labels, gotSamples[j].Labels) <= 0
+	})
+	if !reflect.DeepEqual(expSamples, gotSamples) {
+		return trace.CompareFailed("    expr: %q, time: %v,\n        exp: %v\n        got: %v",
+			tc.Expr, tc.EvalTime, parsedSamplesString(expSamples), parsedSamplesString(gotSamples))
+	}
+	return nil
+}
+
+// query executes an instant query at the specified time.
+func query(ctx context.Context, qs string, t time.Time, engine *promql.Engine, queryable storage.Queryable) (promql.Vector, error) {
+	q, err := engine.NewInstantQuery(queryable, qs, t)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	res := q.Exec(ctx)
+	if res.Err != nil {
+		return nil, trace.Wrap(res.Err)
+	}
+	switch v := res.Value.(type) {
+	case promql.Vector:
+		return v, nil
+	case promql.Scalar:
+		return promql.Vector{promql.Sample{
+			Point:  promql.Point(v),
+			Metric: labels.Labels{},
+		}}, nil
+	default:
+		return nil, trace.BadParameter("rule result is not a vector or scalar")
+	}
+}
+
+type sample struct {
+	Labels string  `yaml:"labels"`
+	Value  float64 `yaml:"value"`
+}
+
+type labelsAndAnnotations []labelAndAnnotation
+
+func (la labelsAndAnnotations) Len() int      { return len(la) }
+func (la labelsAndAnnotations) Swap(i, j int) { la[i], la[j] = la[j], la[i] }
+func (la labelsAndAnnotations) Less(i, j int) bool {
+	diff := labels.Compare(la[i].Labels, la[j].Labels)
+	if diff != 0 {
+		return diff < 0
+	}
+	return labels.Compare(la[i].Annotations, la[j].Annotations) < 0
+}
+
+func (la labelsAndAnnotations) String() string {
+	var items []string
+	for _, l := range la {
+		items = append(items, l.String())
+	}
+	return "[" + strings.Join(items, ", ") + "]"
+}
+
+type labelAndAnnotation struct {
+	Labels      labels.Labels
+	Annotations labels.Labels
+}
+
+func (la labelAndAnnotation) String() string {
+	return "Labels:" + la.Labels.String() + " Annotations:" + la.Annotations.String()
+}
+
+// parsedSample is a sample with parsed labels.
+type parsedSample struct {
+	Labels labels.Labels
+	Value  float64
+}
+
+func (ps parsedSample) String() string {
+	return ps.Labels.String() + " " + strconv.FormatFloat(ps.Value, 'E', -1, 64)
+}
+
+func parsedSamplesString(samples []parsedSample) string {
+	if len(samples) == 0 {
+		return "nil"
+	}
+	var items []string
+	for _, s := range samples {
+		items = append(items, s.String())
+	}
+	return strings.Join(items, ", ")
+}
+
+// seriesLoader loads the input series of a test group into a temporary
+// TSDB as the evaluation time advances.
+type seriesLoader struct {
+	// db is the storage with the loaded series
+	db *tsdb.DB
+	// dir is the storage directory
+	dir string
+	// engine is the PromQL engine that queries the storage
+	engine *promql.Engine
+	// series is the input series with the samples that are not loaded yet
+	series []loadedSeries
+}
+
+// loadedSeries is an input series and its samples
+type loadedSeries struct {
+	// labels is the series labels
+	labels labels.Labels
+	// samples is the series samples
+	samples []promql.Point
+}
+
+// newSeriesLoader parses the input series with the samples spaced by the provided
+// interval and returns the loader of the series. Subqueries without a step
+// are evaluated with the provided subquery interval.
+func newSeriesLoader(interval time.Duration, input []series, subqueryInterval time.Duration) (*seriesLoader, error) {
+	loader := &seriesLoader{}
+	for _, s := range input {
+		metric, values, err := parser.ParseSeriesDesc(fmt.Sprintf("%v %v", s.Series, s.Values))
+		if err != nil {
+			return nil, trace.BadParameter("invalid input series %v: %v", s.Series, err)
+		}
+		loaded := loadedSeries{labels: metric}
+		for i, value := range values {
+			if !value.Omitted {
+				loaded.samples = append(loaded.samples, promql.Point{
+					T: int64(time.Duration(i) * interval / time.Millisecond),
+					V: value.Value,
+				})
+			}
+		}
+		loader.series = append(loader.series, loaded)
+	}
+
+	dir, err := ioutil.TempDir("", "rulestest-storage")
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	// Samples of a series are loaded sequentially, so the head has to
+	// accept samples across the whole test duration.
+	opts := tsdb.DefaultOptions()
+	opts.MinBlockDuration = int64(24 * time.Hour / time.Millisecond)
+	opts.MaxBlockDuration = int64(24 * time.Hour / time.Millisecond)
+	loader.db, err = tsdb.Open(dir, nil, nil, opts, tsdb.NewDBStats())
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, trace.Wrap(err)
+	}
+	loader.dir = dir
+	loader.engine = promql.NewEngine(promql.EngineOpts{
+		MaxSamples: 10000,
+		Timeout:    100 * time.Second,
+		NoStepSubqueryIntervalFn: func(int64) int64 {
+			return int64(subqueryInterval / time.Millisecond)
+		},
+		EnableAtModifier: true,
+	})
+	return loader, nil
+}
+
+// appendTill loads the samples up to the specified time.
+func (l *seriesLoader) appendTill(ts time.Time) error {
+	max := int64(ts.Sub(time.Unix(0, 0).UTC()) / time.Millisecond)
+	app := l.db.Appender(context.Background())
+	for i, s := range l.series {
+		n := 0
+		for ; n < len(s.samples) && s.samples[n].T <= max; n++ {
+			if _, err := app.Append(0, s.labels, s.samples[n].T, s.samples[n].V); err != nil {
+				app.Rollback()
+				return trace.Wrap(err)
+			}
+		}
+		l.series[i].samples = s.samples[n:]
+	}
+	return trace.Wrap(app.Commit())
+}
+
+// Close closes the storage and removes its directory.
+func (l *seriesLoader) Close() error {
+	err := l.db.Close()
+	os.RemoveAll(l.dir)
+	return trace.Wrap(err)
+}
+
+// defaultEvaluationInterval is the default rule evaluation interval.
+const defaultEvaluationInterval = time.Minute
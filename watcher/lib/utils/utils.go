@@ -58,3 +58,18 @@ func WaitForAPI(ctx context.Context, client APIClient) (err error) {
 		}
 	}
 }
+
+// RunPeriodically calls fn right away and then with the specified interval
+// until the provided context is cancelled
+func RunPeriodically(ctx context.Context, interval time.Duration, fn func(context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		fn(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,486 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+	"github.com/gravitational/monitoring-app/watcher/lib/grafana"
+	"github.com/gravitational/monitoring-app/watcher/lib/kubernetes"
+	"github.com/gravitational/monitoring-app/watcher/lib/utils"
+
+	"github.com/ghodss/yaml"
+	"github.com/gravitational/rigging"
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// receiveAndSyncAccess listens on the provided channels that receive updates of
+// Grafana team, service account and permissions resources, resets the
+// permissions of deleted resources and syncs the rest of them.
+//
+// Resources are also synced periodically to revert changes made in Grafana.
+func receiveAndSyncAccess(ctx context.Context, client *grafana.Client, configMaps corev1.ConfigMapInterface,
+	teamCh, serviceAccountCh, permissionsCh <-chan kubernetes.ConfigMapUpdate) {
+	ticker := time.NewTicker(constants.GarbageCollectionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-teamCh:
+		case <-serviceAccountCh:
+		case update := <-permissionsCh:
+			if update.EventType == watch.Deleted {
+				log := log.WithField("configmap", update.ResourceUpdate.Meta())
+				err := resetPermissions(ctx, client, []byte(update.Data[constants.ResourceSpecKey]))
+				if err != nil && !trace.IsNotFound(err) {
+					log.Warnf("Failed to reset Grafana permissions: %v.", trace.DebugReport(err))
+				}
+			}
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := syncAccess(ctx, client, configMaps); err != nil {
+			log.Warnf("Failed to sync Grafana access control: %v.", trace.DebugReport(err))
+		}
+	}
+}
+
+// syncAccess creates or updates Grafana teams and service accounts and sets
+// folder and dashboard permissions defined by the resource ConfigMaps.
+//
+// Teams and service accounts created by the watcher are recorded in the
+// Grafana access ConfigMap and deleted once no resource defines them, e.g.
+// after their resources have been deleted or renamed.
+//
+// Teams are synced first so permissions can be granted to them.
+func syncAccess(ctx context.Context, client *grafana.Client, configMaps corev1.ConfigMapInterface) error {
+	var errors []error
+	managed, err := getManagedAccess(ctx, configMaps)
+	if err != nil {
+		// Objects are still created and updated but nothing is deleted
+		// until the created ones can be recorded
+		errors = append(errors, trace.Wrap(err))
+	}
+	teams, err := teamSync.sync(ctx, client, configMaps, managed.teams())
+	if err != nil {
+		errors = append(errors, trace.Wrap(err))
+	}
+	serviceAccounts, err := serviceAccountSync.sync(ctx, client, configMaps, managed.serviceAccounts())
+	if err != nil {
+		errors = append(errors, trace.Wrap(err))
+	}
+	if managed != nil {
+		updated := &managedAccess{Teams: teams, ServiceAccounts: serviceAccounts}
+		if err := setManagedAccess(ctx, configMaps, managed, updated); err != nil {
+			errors = append(errors, trace.Wrap(err))
+		}
+	}
+
+	resources, err := listGrafanaResources(ctx, configMaps, nil, constants.MonitoringUpdateGrafanaPermissions)
+	if err != nil {
+		return trace.NewAggregate(append(errors, trace.Wrap(err))...)
+	}
+	for _, resource := range resources {
+		if err := setPermissions(ctx, client, resource.spec); err != nil {
+			errors = append(errors, trace.Wrap(err, "%v %v", resource.origin.Kind, resource.origin.Name))
+		}
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// objectSync syncs the Grafana objects of one kind, teams or service accounts,
+// with their resources.
+type objectSync struct {
+	// monitoringUpdate is the monitoring label value of the resource ConfigMaps
+	monitoringUpdate string
+	// upsert creates or updates the object defined by the resource spec. It
+	// returns the object name, if the spec is valid, and whether the object
+	// has been created
+	upsert func(ctx context.Context, client *grafana.Client, spec []byte) (name string, created bool, err error)
+	// remove deletes the object with the specified name
+	remove func(ctx context.Context, client *grafana.Client, name string) error
+}
+
+// sync creates or updates the objects defined by the resources and deletes the
+// objects with the provided managed names that are no longer defined. It
+// returns the names of the objects created by the watcher after the sync.
+//
+// Nothing is deleted while some of the resources are invalid, since the
+// objects they define are not known.
+func (s objectSync) sync(ctx context.Context, client *grafana.Client, configMaps corev1.ConfigMapInterface, managed []string) ([]string, error) {
+	resources, err := listGrafanaResources(ctx, configMaps, nil, s.monitoringUpdate)
+	if err != nil {
+		return managed, trace.Wrap(err)
+	}
+	owned := make(map[string]struct{}, len(managed))
+	for _, name := range managed {
+		owned[name] = struct{}{}
+	}
+	var errors []error
+	defined := make(map[string]struct{}, len(resources))
+	complete := true
+	for _, resource := range resources {
+		name, created, err := s.upsert(ctx, client, resource.spec)
+		if err != nil {
+			errors = append(errors, trace.Wrap(err, "%v %v", resource.origin.Kind, resource.origin.Name))
+		}
+		if name == "" {
+			complete = false
+			continue
+		}
+		defined[name] = struct{}{}
+		if created {
+			owned[name] = struct{}{}
+		}
+	}
+	for name := range owned {
+		if _, ok := defined[name]; ok || !complete {
+			continue
+		}
+		err := s.remove(ctx, client, name)
+		if err != nil && !trace.IsNotFound(err) {
+			errors = append(errors, trace.Wrap(err))
+			continue
+		}
+		delete(owned, name)
+	}
+	names := make([]string, 0, len(owned))
+	for name := range owned {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, trace.NewAggregate(errors...)
+}
+
+var (
+	// teamSync syncs Grafana teams
+	teamSync = objectSync{
+		monitoringUpdate: constants.MonitoringUpdateGrafanaTeam,
+		upsert: func(ctx context.Context, client *grafana.Client, spec []byte) (string, bool, error) {
+			team, err := parseTeam(spec)
+			if err != nil {
+				return "", false, trace.Wrap(err)
+			}
+			created, err := client.UpsertTeam(ctx, *team)
+			return team.Name, created, trace.Wrap(err)
+		},
+		remove: func(ctx context.Context, client *grafana.Client, name string) error {
+			return client.DeleteTeam(ctx, name)
+		},
+	}
+	// serviceAccountSync syncs Grafana service accounts
+	serviceAccountSync = objectSync{
+		monitoringUpdate: constants.MonitoringUpdateGrafanaServiceAccount,
+		upsert: func(ctx context.Context, client *grafana.Client, spec []byte) (string, bool, error) {
+			account, err := parseServiceAccount(spec)
+			if err != nil {
+				return "", false, trace.Wrap(err)
+			}
+			created, err := client.UpsertServiceAccount(ctx, *account)
+			return account.Name, created, trace.Wrap(err)
+		},
+		remove: func(ctx context.Context, client *grafana.Client, name string) error {
+			return client.DeleteServiceAccount(ctx, name)
+		},
+	}
+)
+
+// managedAccess is the Grafana teams and service accounts created by the watcher
+type managedAccess struct {
+	// Teams is the list of team names
+	Teams []string
+	// ServiceAccounts is the list of service account names
+	ServiceAccounts []string
+}
+
+// teams returns the names of teams created by the watcher
+func (r *managedAccess) teams() []string {
+	if r == nil {
+		return nil
+	}
+	return r.Teams
+}
+
+// serviceAccounts returns the names of service accounts created by the watcher
+func (r *managedAccess) serviceAccounts() []string {
+	if r == nil {
+		return nil
+	}
+	return r.ServiceAccounts
+}
+
+// getManagedAccess returns the Grafana teams and service accounts created by
+// the watcher recorded in the Grafana access ConfigMap
+func getManagedAccess(ctx context.Context, configMaps corev1.ConfigMapInterface) (*managedAccess, error) {
+	configMap, err := configMaps.Get(ctx, constants.GrafanaAccessConfigMap, metav1.GetOptions{})
+	if err != nil {
+		return nil, trace.Wrap(rigging.ConvertError(err), "failed to query ConfigMap %v", constants.GrafanaAccessConfigMap)
+	}
+	managed := &managedAccess{}
+	for key, names := range map[string]*[]string{
+		managedTeamsKey:           &managed.Teams,
+		managedServiceAccountsKey: &managed.ServiceAccounts,
+	} {
+		if data, ok := configMap.Data[key]; ok {
+			if err := json.Unmarshal([]byte(data), names); err != nil {
+				return nil, trace.Wrap(err, "invalid key %v of ConfigMap %v", key, constants.GrafanaAccessConfigMap)
+			}
+		}
+	}
+	return managed, nil
+}
+
+// namesEqual returns true if the provided name lists are equal
+func namesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// setManagedAccess records the updated Grafana teams and service accounts
+// created by the watcher in the Grafana access ConfigMap if they have changed
+func setManagedAccess(ctx context.Context, configMaps corev1.ConfigMapInterface, existing, updated *managedAccess) error {
+	if namesEqual(existing.Teams, updated.Teams) && namesEqual(existing.ServiceAccounts, updated.ServiceAccounts) {
+		return nil
+	}
+	data := make(map[string]string)
+	for key, names := range map[string][]string{
+		managedTeamsKey:           updated.Teams,
+		managedServiceAccountsKey: updated.ServiceAccounts,
+	} {
+		value, err := json.Marshal(names)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		data[key] = string(value)
+	}
+	patch, err := json.Marshal(map[string]interface{}{"data": data})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	_, err = configMaps.Patch(ctx, constants.GrafanaAccessConfigMap, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return trace.Wrap(rigging.ConvertError(err), "failed to update ConfigMap %v", constants.GrafanaAccessConfigMap)
+	}
+	return nil
+}
+
+// setPermissions replaces the permissions of the folder or the dashboard
+// with the ones defined by the provided spec
+func setPermissions(ctx context.Context, client *grafana.Client, spec []byte) error {
+	permissions, err := parsePermissions(spec)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return client.SetPermissions(ctx, *permissions)
+}
+
+// resetPermissions restores the default permissions of the folder or the
+// dashboard the provided spec defines permissions of
+func resetPermissions(ctx context.Context, client *grafana.Client, spec []byte) error {
+	permissions, err := parsePermissions(spec)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	return client.ResetPermissions(ctx, *permissions)
+}
+
+// parseTeam parses and validates the team resource from the provided spec.
+func parseTeam(spec []byte) (*grafana.Team, error) {
+	if len(bytes.TrimSpace(spec)) == 0 {
+		return nil, trace.NotFound("empty configuration")
+	}
+	var resource teamResource
+	if err := yaml.Unmarshal(spec, &resource); err != nil {
+		return nil, trace.Wrap(err, "failed to unmarshal %s", spec)
+	}
+	if resource.Name == "" {
+		return nil, trace.BadParameter("team name is required")
+	}
+	return &grafana.Team{
+		Name:    resource.Name,
+		Email:   resource.Spec.Email,
+		Members: resource.Spec.Members,
+	}, nil
+}
+
+// parseServiceAccount parses and validates the service account resource from the provided spec.
+func parseServiceAccount(spec []byte) (*grafana.ServiceAccount, error) {
+	if len(bytes.TrimSpace(spec)) == 0 {
+		return nil, trace.NotFound("empty configuration")
+	}
+	var resource serviceAccountResource
+	if err := yaml.Unmarshal(spec, &resource); err != nil {
+		return nil, trace.Wrap(err, "failed to unmarshal %s", spec)
+	}
+	if resource.Name == "" {
+		return nil, trace.BadParameter("service account name is required")
+	}
+	role := resource.Spec.Role
+	if role == "" {
+		role = grafanaViewerRole
+	}
+	if !utils.OneOf(role, grafanaServiceAccountRoles) {
+		return nil, trace.BadParameter("service account %q: role must be one of %v, got %q",
+			resource.Name, grafanaServiceAccountRoles, role)
+	}
+	return &grafana.ServiceAccount{
+		Name:     resource.Name,
+		Role:     role,
+		Disabled: resource.Spec.Disabled,
+	}, nil
+}
+
+// parsePermissions parses and validates the permissions resource from the provided spec.
+func parsePermissions(spec []byte) (*grafana.Permissions, error) {
+	if len(bytes.TrimSpace(spec)) == 0 {
+		return nil, trace.NotFound("empty configuration")
+	}
+	var resource permissionsResource
+	if err := yaml.Unmarshal(spec, &resource); err != nil {
+		return nil, trace.Wrap(err, "failed to unmarshal %s", spec)
+	}
+	if (resource.Spec.Folder == "") == (resource.Spec.Dashboard == "") {
+		return nil, trace.BadParameter("permissions %q: exactly one of folder and dashboard is required", resource.Name)
+	}
+	permissions := &grafana.Permissions{
+		Folder:       resource.Spec.Folder,
+		DashboardUID: resource.Spec.Dashboard,
+	}
+	for _, item := range resource.Spec.Permissions {
+		set := 0
+		for _, grantee := range []string{item.Team, item.User, item.Role} {
+			if grantee != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return nil, trace.BadParameter("permissions %q: exactly one of team, user and role is required", resource.Name)
+		}
+		if item.Role != "" && !utils.OneOf(item.Role, grafanaPermissionRoles) {
+			return nil, trace.BadParameter("permissions %q: role must be one of %v, got %q",
+				resource.Name, grafanaPermissionRoles, item.Role)
+		}
+		if !utils.OneOf(item.Permission, grafana.PermissionNames) {
+			return nil, trace.BadParameter("permissions %q: permission must be one of %v, got %q",
+				resource.Name, grafana.PermissionNames, item.Permission)
+		}
+		permissions.Items = append(permissions.Items, grafana.Permission{
+			Team:       item.Team,
+			User:       item.User,
+			Role:       item.Role,
+			Permission: item.Permission,
+		})
+	}
+	return permissions, nil
+}
+
+// teamResource defines the Grafana team resource
+type teamResource struct {
+	// Metadata is the resource metadata, the name is the team name
+	Metadata `json:"metadata" yaml:"metadata"`
+	// Spec defines the team
+	Spec teamSpec `json:"spec" yaml:"spec"`
+}
+
+// teamSpec defines a Grafana team
+type teamSpec struct {
+	// Email is the optional team email
+	Email string `json:"email,omitempty" yaml:"email,omitempty"`
+	// Members is the list of logins or emails of team members
+	Members []string `json:"members,omitempty" yaml:"members,omitempty"`
+}
+
+// serviceAccountResource defines the Grafana service account resource
+type serviceAccountResource struct {
+	// Metadata is the resource metadata, the name is the service account name
+	Metadata `json:"metadata" yaml:"metadata"`
+	// Spec defines the service account
+	Spec serviceAccountSpec `json:"spec" yaml:"spec"`
+}
+
+// serviceAccountSpec defines a Grafana service account
+type serviceAccountSpec struct {
+	// Role is the organization role of the service account, Viewer by default
+	Role string `json:"role,omitempty" yaml:"role,omitempty"`
+	// Disabled is whether the service account is disabled
+	Disabled bool `json:"disabled,omitempty" yaml:"disabled,omitempty"`
+}
+
+// permissionsResource defines the Grafana folder or dashboard permissions resource
+type permissionsResource struct {
+	// Metadata is the resource metadata
+	Metadata `json:"metadata" yaml:"metadata"`
+	// Spec defines the permissions
+	Spec permissionsSpec `json:"spec" yaml:"spec"`
+}
+
+// permissionsSpec defines the permissions of a Grafana folder or dashboard
+type permissionsSpec struct {
+	// Folder is the title of the folder
+	Folder string `json:"folder,omitempty" yaml:"folder,omitempty"`
+	// Dashboard is the uid of the dashboard
+	Dashboard string `json:"dashboard,omitempty" yaml:"dashboard,omitempty"`
+	// Permissions is the list of permissions replacing the existing ones
+	Permissions []permissionSpec `json:"permissions" yaml:"permissions"`
+}
+
+// permissionSpec grants a permission to a team, a user or an organization role
+type permissionSpec struct {
+	// Team is the name of the team
+	Team string `json:"team,omitempty" yaml:"team,omitempty"`
+	// User is the login or email of the user
+	User string `json:"user,omitempty" yaml:"user,omitempty"`
+	// Role is the organization role, Viewer or Editor
+	Role string `json:"role,omitempty" yaml:"role,omitempty"`
+	// Permission is the permission level, View, Edit or Admin
+	Permission string `json:"permission" yaml:"permission"`
+}
+
+const (
+	// grafanaViewerRole is the Grafana organization role with read-only access.
+	grafanaViewerRole = "Viewer"
+	// managedTeamsKey is the Grafana access ConfigMap key with the JSON list
+	// of names of teams created by the watcher
+	managedTeamsKey = "teams"
+	// managedServiceAccountsKey is the Grafana access ConfigMap key with the
+	// JSON list of names of service accounts created by the watcher
+	managedServiceAccountsKey = "serviceAccounts"
+)
+
+var (
+	// grafanaServiceAccountRoles is the list of organization roles of service accounts.
+	grafanaServiceAccountRoles = []string{grafanaViewerRole, "Editor", "Admin"}
+	// grafanaPermissionRoles is the list of organization roles folder and dashboard permissions are granted to.
+	grafanaPermissionRoles = []string{grafanaViewerRole, "Editor"}
+)
@@ -0,0 +1,176 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/alertpacks"
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+	"github.com/gravitational/monitoring-app/watcher/lib/resources"
+
+	"github.com/ghodss/yaml"
+	"github.com/gravitational/rigging"
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// syncAlertPacks creates PrometheusRules for the enabled built-in alert packs
+// and deletes the ones of disabled packs.
+//
+// Packs are configured by alert pack ConfigMaps, packs without a ConfigMap
+// use default settings. Packs with invalid ConfigMaps are left as they are
+// until the ConfigMap is fixed.
+func syncAlertPacks(ctx context.Context, client resources.Resources, configMaps corev1.ConfigMapInterface) error {
+	list, err := configMaps.List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%v=%v", constants.MonitoringLabel, constants.MonitoringUpdateAlertPack),
+	})
+	if err != nil {
+		return trace.Wrap(rigging.ConvertError(err))
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		return list.Items[i].Name < list.Items[j].Name
+	})
+
+	var errors []error
+	configs := make(map[string]alertpacks.Config)
+	// skipped is the set of packs with invalid configuration
+	skipped := make(map[string]struct{})
+	for _, configMap := range list.Items {
+		spec := []byte(configMap.Data[constants.ResourceSpecKey])
+		name, config, err := parseAlertPack(spec)
+		if err == nil {
+			if _, ok := configs[name]; ok {
+				err = trace.AlreadyExists("alert pack %v is configured by multiple ConfigMaps", name)
+			}
+		}
+		if err != nil {
+			errors = append(errors, trace.Wrap(err, "ConfigMap %v", configMap.Name))
+			var pack alertPack
+			if yaml.Unmarshal(spec, &pack) == nil {
+				skipped[pack.Name] = struct{}{}
+			}
+			continue
+		}
+		configs[name] = *config
+	}
+
+	for _, pack := range alertpacks.All() {
+		if _, ok := skipped[pack.Name]; ok {
+			continue
+		}
+		alert, err := pack.Alert(configs[pack.Name])
+		if err != nil {
+			errors = append(errors, trace.Wrap(err, "alert pack %v", pack.Name))
+			continue
+		}
+		if alert == nil {
+			log.Infof("Alert pack %v is disabled.", pack.Name)
+			err = client.DeleteAlert(alertpacks.CRDName(pack.Name))
+			if err != nil && !trace.IsNotFound(err) {
+				errors = append(errors, trace.Wrap(err, "alert pack %v", pack.Name))
+			}
+			continue
+		}
+		if err := client.UpsertAlert(*alert); err != nil {
+			errors = append(errors, trace.Wrap(err, "alert pack %v", pack.Name))
+		}
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// parseAlertPack parses and validates the alert pack resource from the
+// provided spec and returns the name of the pack it configures.
+func parseAlertPack(spec []byte) (string, *alertpacks.Config, error) {
+	if len(bytes.TrimSpace(spec)) == 0 {
+		return "", nil, trace.NotFound("empty configuration")
+	}
+
+	var pack alertPack
+	if err := yaml.Unmarshal(spec, &pack); err != nil {
+		return "", nil, trace.Wrap(err, "failed to unmarshal %s", spec)
+	}
+
+	config, err := pack.config()
+	if err != nil {
+		return "", nil, trace.Wrap(err)
+	}
+	return pack.Name, config, nil
+}
+
+// alertPack defines the alert pack resource that configures a built-in alert pack
+type alertPack struct {
+	// Metadata is the resource metadata, the name is the name of the built-in pack
+	Metadata `json:"metadata" yaml:"metadata"`
+	// Spec defines the alert pack configuration
+	Spec alertPackSpec `json:"spec" yaml:"spec"`
+}
+
+// alertPackSpec defines the configuration of a built-in alert pack
+type alertPackSpec struct {
+	// Enabled is whether the pack is enabled, defaults to whether the pack
+	// is enabled by default
+	Enabled *bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// Alerts configures individual alerts by alert name
+	Alerts map[string]alertPackAlertSpec `json:"alerts,omitempty" yaml:"alerts,omitempty"`
+}
+
+// alertPackAlertSpec defines the configuration of a built-in alert
+type alertPackAlertSpec struct {
+	// Enabled is whether the alert is enabled, true by default
+	Enabled *bool `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	// Threshold optionally overrides the alert threshold
+	Threshold *float64 `json:"threshold,omitempty" yaml:"threshold,omitempty"`
+	// For optionally overrides the duration the alert condition has to hold
+	For *duration `json:"for,omitempty" yaml:"for,omitempty"`
+}
+
+// config returns the alert pack configuration this resource describes.
+func (p alertPack) config() (*alertpacks.Config, error) {
+	if p.Name == "" {
+		return nil, trace.BadParameter("alert pack name is required")
+	}
+	config := &alertpacks.Config{
+		Enabled: p.Spec.Enabled,
+		Alerts:  make(map[string]alertpacks.AlertConfig),
+	}
+	for name, alert := range p.Spec.Alerts {
+		alertConfig := alertpacks.AlertConfig{
+			Disabled:  alert.Enabled != nil && !*alert.Enabled,
+			Threshold: alert.Threshold,
+		}
+		if alert.For != nil {
+			forDuration := time.Duration(*alert.For)
+			alertConfig.For = &forDuration
+		}
+		config.Alerts[name] = alertConfig
+	}
+	pack, err := alertpacks.Get(p.Name)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	if _, err := pack.Alert(*config); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return config, nil
+}
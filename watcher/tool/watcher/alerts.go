@@ -19,20 +19,40 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/gravitational/monitoring-app/watcher/lib/alertmanager"
 	"github.com/gravitational/monitoring-app/watcher/lib/constants"
 	"github.com/gravitational/monitoring-app/watcher/lib/kubernetes"
+	"github.com/gravitational/monitoring-app/watcher/lib/lint"
 	"github.com/gravitational/monitoring-app/watcher/lib/resources"
+	"github.com/gravitational/monitoring-app/watcher/lib/utils"
 
 	"github.com/ghodss/yaml"
+	"github.com/gravitational/rigging"
 	"github.com/gravitational/trace"
+	commoncfg "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
 	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
 	kubeapi "k8s.io/client-go/kubernetes"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
-func runAlertsWatcher(ctx context.Context, kubernetesClient *kubernetes.Client, kubeconfig string) error {
+func runAlertsWatcher(ctx context.Context, kubernetesClient *kubernetes.Client, kubeconfig, lintPolicy string) error {
+	policy, err := lint.LoadPolicy(lintPolicy)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
 	monitoringClient, err := kubernetes.NewMonitoringClient(kubeconfig)
 	if err != nil {
 		return trace.Wrap(err)
@@ -47,6 +67,10 @@ func runAlertsWatcher(ctx context.Context, kubernetesClient *kubernetes.Client,
 		return trace.Wrap(err)
 	}
 
+	if err := rClient.CheckRuleSelector(); err != nil {
+		log.WithError(err).Warn("Failed to check Prometheus rule selector.")
+	}
+
 	alertLabel, err := kubernetes.MatchLabel(constants.MonitoringLabel, constants.MonitoringUpdateAlert)
 	if err != nil {
 		return trace.Wrap(err)
@@ -57,52 +81,146 @@ func runAlertsWatcher(ctx context.Context, kubernetesClient *kubernetes.Client,
 		return trace.Wrap(err)
 	}
 
+	recordingRuleLabel, err := kubernetes.MatchLabel(constants.MonitoringLabel, constants.MonitoringUpdateRecordingRule)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	sloLabel, err := kubernetes.MatchLabel(constants.MonitoringLabel, constants.MonitoringUpdateSLO)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	alertPackLabel, err := kubernetes.MatchLabel(constants.MonitoringLabel, constants.MonitoringUpdateAlertPack)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
 	smtpLabel, err := kubernetes.MatchLabel(constants.MonitoringLabel, constants.MonitoringUpdateSMTP)
 	if err != nil {
 		return trace.Wrap(err)
 	}
 
+	alertmanagerClient, err := alertmanager.NewClient("")
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
 	alertCh := make(chan kubernetes.ConfigMapUpdate)
 	alertTargetCh := make(chan kubernetes.ConfigMapUpdate)
+	recordingRuleCh := make(chan kubernetes.ConfigMapUpdate)
+	sloCh := make(chan kubernetes.ConfigMapUpdate)
+	alertPackCh := make(chan kubernetes.ConfigMapUpdate)
 	configmaps := []kubernetes.ConfigMap{
 		{Selector: alertLabel, RecvCh: alertCh},
 		{Selector: targetLabel, RecvCh: alertTargetCh},
+		{Selector: recordingRuleLabel, RecvCh: recordingRuleCh},
+		{Selector: sloLabel, RecvCh: sloCh},
+		{Selector: alertPackLabel, RecvCh: alertPackCh},
 	}
 	smtpCh := make(chan kubernetes.SecretUpdate)
 
+	err = syncAlertPacks(ctx, rClient, kubernetesClient.CoreV1().ConfigMaps(constants.MonitoringNamespace))
+	if err != nil {
+		log.WithError(err).Warn("Failed to sync alert packs.")
+	}
+
 	go kubernetesClient.WatchConfigMaps(ctx, configmaps...)
+	go utils.RunPeriodically(ctx, constants.GarbageCollectionInterval, func(ctx context.Context) {
+		collectRules(ctx, rClient, kubernetesClient.CoreV1().ConfigMaps(constants.MonitoringNamespace))
+	})
 	go kubernetesClient.WatchSecrets(ctx, kubernetes.Secret{Selector: smtpLabel, RecvCh: smtpCh})
-	receiverLoop(ctx, kubernetesClient.Clientset, rClient,
-		alertCh, alertTargetCh, smtpCh)
+	receiverLoop(ctx, kubernetesClient.Clientset, rClient, *policy, alertmanagerClient,
+		alertCh, alertTargetCh, recordingRuleCh, sloCh, alertPackCh, smtpCh)
 
 	return nil
 }
 
-func receiverLoop(ctx context.Context, kubeClient *kubeapi.Clientset, rClient resources.Resources,
-	alertCh, alertTargetCh <-chan kubernetes.ConfigMapUpdate, smtpCh <-chan kubernetes.SecretUpdate) {
+func receiverLoop(ctx context.Context, kubeClient *kubeapi.Clientset, rClient resources.Resources, policy lint.Policy,
+	alertmanagerClient *alertmanager.Client, alertCh, alertTargetCh, recordingRuleCh, sloCh, alertPackCh <-chan kubernetes.ConfigMapUpdate,
+	smtpCh <-chan kubernetes.SecretUpdate) {
+	events := kubeClient.CoreV1().Events(constants.MonitoringNamespace)
 	for {
 		select {
 		case update := <-alertCh:
+			log := log.WithField("configmap", update.ResourceUpdate.Meta())
+			switch update.EventType {
+			case watch.Added, watch.Modified:
+				warnings, err := createAlert(ctx, rClient, policy, update, log)
+				if err != nil {
+					log.Warnf("Failed to create alert from %v: %v.", update.Data, trace.DebugReport(err))
+				}
+				recordStatus(ctx, events, update, err, log)
+				recordWarnings(ctx, events, update, warnings, log)
+			case watch.Deleted:
+				if err := deleteAlert(rClient, update, log); err != nil {
+					log.Warnf("Failed to delete alert from %v: %v.", update.Data, trace.DebugReport(err))
+				}
+			}
+		case update := <-recordingRuleCh:
+			log := log.WithField("configmap", update.ResourceUpdate.Meta())
+			spec := []byte(update.Data[constants.ResourceSpecKey])
+			switch update.EventType {
+			case watch.Added, watch.Modified:
+				warnings, err := createRecordingRules(rClient, update, log)
+				if err != nil {
+					log.Warnf("Failed to create recording rules from spec %s: %v.", spec, trace.DebugReport(err))
+				}
+				recordStatus(ctx, events, update, err, log)
+				recordWarnings(ctx, events, update, warnings, log)
+			case watch.Deleted:
+				if err := deleteRecordingRules(rClient, spec, log); err != nil {
+					log.Warnf("Failed to delete recording rules from spec %s: %v.", spec, trace.DebugReport(err))
+				}
+			}
+		case update := <-sloCh:
 			log := log.WithField("configmap", update.ResourceUpdate.Meta())
 			spec := []byte(update.Data[constants.ResourceSpecKey])
 			switch update.EventType {
 			case watch.Added, watch.Modified:
-				if err := createAlert(ctx, rClient, spec, log); err != nil {
-					log.Warnf("Failed to create alert from spec %s: %v.", spec, trace.DebugReport(err))
+				warnings, err := createSLO(rClient, update, log)
+				if err != nil {
+					log.Warnf("Failed to create SLO from spec %s: %v.", spec, trace.DebugReport(err))
 				}
+				recordStatus(ctx, events, update, err, log)
+				recordWarnings(ctx, events, update, warnings, log)
 			case watch.Deleted:
-				if err := deleteAlert(rClient, spec, log); err != nil {
-					log.Warnf("Failed to delete alert from spec %s: %v.", spec, trace.DebugReport(err))
+				if err := deleteSLO(rClient, spec, log); err != nil {
+					log.Warnf("Failed to delete SLO from spec %s: %v.", spec, trace.DebugReport(err))
 				}
 			}
+		case update := <-alertPackCh:
+			log := log.WithField("configmap", update.ResourceUpdate.Meta())
+			if update.EventType == watch.Added || update.EventType == watch.Modified {
+				_, _, err := parseAlertPack([]byte(update.Data[constants.ResourceSpecKey]))
+				recordStatus(ctx, events, update, err, log)
+			}
+			err := syncAlertPacks(ctx, rClient, kubeClient.CoreV1().ConfigMaps(constants.MonitoringNamespace))
+			if err != nil {
+				log.Warnf("Failed to sync alert packs: %v.", trace.DebugReport(err))
+			}
 		case update := <-smtpCh:
 			log := log.WithField("secret", update.ResourceUpdate.Meta())
 			spec := update.Data[constants.ResourceSpecKey]
 			switch update.EventType {
 			case watch.Added, watch.Modified:
-				if err := updateSMTPConfig(rClient, spec, log); err != nil {
-					log.Warnf("Failed to update SMTP configuration from spec %s: %v.", spec, trace.DebugReport(err))
+				config, err := updateSMTPConfig(rClient, spec, log)
+				if err != nil {
+					// Spec is not logged as it contains credentials.
+					log.Warnf("Failed to update SMTP configuration: %v.", trace.DebugReport(err))
+					continue
+				}
+				if config.Spec.Host == "" {
+					continue
 				}
+				// The check can take a while if the server is unreachable.
+				go func() {
+					err := checkSMTPConfig(ctx, rClient, kubeClient.CoreV1().Secrets(update.Namespace), events,
+						update, *config, log)
+					if err != nil {
+						log.Warnf("Failed to check SMTP configuration: %v.", trace.DebugReport(err))
+					}
+				}()
 			case watch.Deleted:
 				if err := deleteSMTPConfig(rClient, log); err != nil {
 					log.Warnf("Failed to delete SMTP configuration: %v.", trace.DebugReport(err))
@@ -115,7 +233,19 @@ func receiverLoop(ctx context.Context, kubeClient *kubeapi.Clientset, rClient re
 			case watch.Added, watch.Modified:
 				if err := updateAlertTarget(rClient, spec, log); err != nil {
 					log.Warnf("Failed to update alert target from spec %s: %v.", spec, trace.DebugReport(err))
+					continue
+				}
+				if update.Annotations[constants.TestAlertAnnotation] != "true" {
+					continue
 				}
+				// The annotation is removed so the test alert is not sent again
+				// when the resource is listed again, e.g. after a restart.
+				err := clearTestAlertAnnotation(ctx, kubeClient.CoreV1().ConfigMaps(update.Namespace), update.Name)
+				if err != nil {
+					log.Warnf("Failed to clear test alert annotation: %v.", trace.DebugReport(err))
+					continue
+				}
+				go sendTestAlert(ctx, alertmanagerClient, rClient, events, update, log)
 			case watch.Deleted:
 				if err := deleteAlertTarget(rClient, log); err != nil {
 					log.Warnf("Failed to delete alert target: %v.", trace.DebugReport(err))
@@ -127,68 +257,180 @@ func receiverLoop(ctx context.Context, kubeClient *kubeapi.Clientset, rClient re
 	}
 }
 
-func createAlert(ctx context.Context, client resources.Resources, spec []byte, log *log.Entry) error {
-	log.Debugf("Creating alert from spec %s.", spec)
+// recordWarnings records problems found when applying the resource update
+// as warning events on the resource.
+func recordWarnings(ctx context.Context, events corev1.EventInterface, update kubernetes.ConfigMapUpdate, warnings []resourceWarning, log *log.Entry) {
+	for _, warning := range warnings {
+		log.Warn(warning.message)
+		err := kubernetes.RecordEvent(ctx, events, update.ObjectReference(kubernetes.KindConfigMap),
+			v1.EventTypeWarning, warning.reason, warning.message)
+		if err != nil {
+			log.WithError(err).Warn("Failed to record resource warning event.")
+		}
+	}
+}
+
+// newWarnings returns warnings with the specified event reason and messages
+// formatted with the provided prefix.
+func newWarnings(reason, prefix string, messages []string) (warnings []resourceWarning) {
+	for _, message := range messages {
+		warnings = append(warnings, resourceWarning{
+			reason:  reason,
+			message: fmt.Sprintf("%v: %v.", prefix, message),
+		})
+	}
+	return warnings
+}
+
+// recordStatus records the result of applying the resource update as an
+// event on the resource.
+func recordStatus(ctx context.Context, events corev1.EventInterface, update kubernetes.ConfigMapUpdate, err error, log *log.Entry) {
+	eventType, reason, message := v1.EventTypeNormal, resourceSyncedReason, "Resource has been applied."
+	if err != nil {
+		eventType, reason, message = v1.EventTypeWarning, resourceSyncFailedReason,
+			fmt.Sprintf("Failed to apply resource: %v.", trace.UserMessage(err))
+	}
+	err = kubernetes.RecordEvent(ctx, events, update.ObjectReference(kubernetes.KindConfigMap),
+		eventType, reason, message)
+	if err != nil {
+		log.WithError(err).Warn("Failed to record resource status event.")
+	}
+}
+
+func createAlert(ctx context.Context, client resources.Resources, policy lint.Policy, update kubernetes.ConfigMapUpdate, log *log.Entry) (warnings []resourceWarning, err error) {
+	log.Debugf("Creating alert from %v.", update.Data)
+
+	resourceAlert, err := parseAlertConfigMap(update.Name, update.Data)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	violations := policy.Lint(resourceAlert.RuleGroups())
+	if len(violations) != 0 && policy.Enforced() {
+		return nil, trace.BadParameter("alert violates lint policy: %v", strings.Join(violations, "; "))
+	}
+	warnings = newWarnings(resourceLintReason, "Lint policy violation", violations)
 
+	owner := update.OwnerReference(kubernetes.KindConfigMap)
+	conflicts, err := client.CheckConflicts(resourceAlert.CRDName, resourceAlert.RuleGroups(), &owner)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	warnings = append(warnings, newWarnings(resourceConflictReason, "Conflicting rule definition", conflicts)...)
+	resourceAlert.Owner = &owner
+	err = client.UpsertAlert(*resourceAlert)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to create task")
+	}
+	return warnings, nil
+}
+
+// parseAlert parses and validates the alert resource from the provided spec.
+func parseAlert(spec []byte) (*resources.Alert, error) {
 	if len(bytes.TrimSpace(spec)) == 0 {
-		return trace.NotFound("empty configuration")
+		return nil, trace.NotFound("empty configuration")
 	}
 
 	var alert alert
 	err := yaml.Unmarshal(spec, &alert)
 	if err != nil {
-		return trace.Wrap(err, "failed to unmarshal %s", spec)
+		return nil, trace.Wrap(err, "failed to unmarshal %s", spec)
 	}
 
-	err = client.UpsertAlert(resources.Alert{
-		CRDName:     alert.Name,
-		AlertName:   alert.Spec.AlertName,
-		GroupName:   alert.Spec.GroupName,
-		Formula:     alert.Spec.Formula,
-		Delay:       alert.Spec.Delay,
-		Labels:      alert.Spec.Labels,
-		Annotations: alert.Spec.Annotations,
-	})
+	resourceAlert, err := alert.resource()
 	if err != nil {
-		return trace.Wrap(err, "failed to create task")
+		return nil, trace.Wrap(err)
 	}
-	return nil
+
+	if err := resourceAlert.Check(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return resourceAlert, nil
 }
 
-func deleteAlert(client resources.Resources, spec []byte, log *log.Entry) error {
-	log.Debugf("Deleting alert from spec %s.", spec)
+func deleteAlert(client resources.Resources, update kubernetes.ConfigMapUpdate, log *log.Entry) error {
+	log.Debugf("Deleting alert from %v.", update.Data)
 
-	var alert alert
-	if err := yaml.Unmarshal(spec, &alert); err != nil {
+	name, err := alertConfigMapName(update.Name, update.Data)
+	if err != nil {
 		return trace.Wrap(err)
 	}
 
-	return client.DeleteAlert(alert.Name)
+	return client.DeleteAlert(name)
 }
 
-func updateSMTPConfig(client resources.Resources, spec []byte, log *log.Entry) error {
-	log.Debugf("Updating SMTP config from spec: %s.", spec)
+func updateSMTPConfig(client resources.Resources, spec []byte, log *log.Entry) (*smtpConfig, error) {
+	log.Debug("Updating SMTP config.")
 	if len(bytes.TrimSpace(spec)) == 0 {
-		return trace.NotFound("empty configuration")
+		return nil, trace.NotFound("empty configuration")
 	}
 
 	var config smtpConfig
 	err := yaml.Unmarshal(spec, &config)
 	if err != nil {
-		return trace.Wrap(err, "failed to unmarshal %s", spec)
+		return nil, trace.Wrap(err, "failed to unmarshal SMTP configuration")
 	}
 
-	err = client.UpsertSMTPConfig(resources.SMTPConfig{
-		Host:     config.Spec.Host,
-		Port:     config.Spec.Port,
-		Username: config.Spec.Username,
-		Password: config.Spec.Password,
-	})
+	err = client.UpsertSMTPConfig(config.Spec.smtpConfig())
 	if err != nil {
-		return trace.Wrap(err)
+		return nil, trace.Wrap(err)
 	}
 
-	return nil
+	return &config, nil
+}
+
+// checkSMTPConfig verifies connectivity to the SMTP server from the provided
+// configuration and records the result as an event on the configuration secret.
+//
+// The test message is sent once per configuration: the hash of the spec it has
+// been sent for is recorded in the secret annotation since the secret is
+// received again after restarts and watch timeouts.
+func checkSMTPConfig(ctx context.Context, client resources.Resources, secrets corev1.SecretInterface,
+	events corev1.EventInterface, update kubernetes.SecretUpdate, config smtpConfig, log *log.Entry) error {
+	hash := smtpSpecHash(update.Data[constants.ResourceSpecKey])
+	var recipients []string
+	if config.Spec.SendTestMessage && update.Annotations[constants.SMTPTestMessageAnnotation] != hash {
+		targets, err := client.GetAlertTargets()
+		if err != nil && !trace.IsNotFound(err) {
+			return trace.Wrap(err)
+		}
+		for _, target := range targets {
+			recipients = append(recipients, target.Email)
+		}
+		if len(recipients) == 0 {
+			log.Warn("No alert targets configured, will not send SMTP test message.")
+		}
+	}
+
+	eventType, reason, message := v1.EventTypeNormal, smtpCheckSucceededReason,
+		fmt.Sprintf("Successfully connected to SMTP server %v:%v.", config.Spec.Host, config.Spec.Port)
+	if len(recipients) != 0 {
+		message = fmt.Sprintf("Successfully sent test message via SMTP server %v:%v to %v.",
+			config.Spec.Host, config.Spec.Port, strings.Join(recipients, ", "))
+	}
+	authSkipped, err := resources.CheckSMTPConfig(ctx, config.Spec.smtpConfig(), recipients...)
+	switch {
+	case err != nil:
+		log.WithError(err).Warn("SMTP connectivity check failed.")
+		eventType, reason, message = v1.EventTypeWarning, smtpCheckFailedReason,
+			fmt.Sprintf("SMTP connectivity check failed: %v.", err)
+	case authSkipped:
+		message = fmt.Sprintf("Successfully connected to SMTP server %v:%v. Credentials were not checked "+
+			"and no test message was sent since the server certificate could not be verified.",
+			config.Spec.Host, config.Spec.Port)
+		log.Info(message)
+	default:
+		log.Info(message)
+		if len(recipients) != 0 {
+			err := setSMTPTestMessageAnnotation(ctx, secrets, update.Name, hash)
+			if err != nil {
+				log.WithError(err).Warn("Failed to record SMTP test message.")
+			}
+		}
+	}
+
+	return kubernetes.RecordEvent(ctx, events, update.ObjectReference(kubernetes.KindSecret),
+		eventType, reason, message)
 }
 
 func deleteSMTPConfig(client resources.Resources, log *log.Entry) error {
@@ -250,7 +492,10 @@ type Metadata struct {
 	Name string `json:"name" yaml:"name"`
 }
 
-// alertSpec defines a monitoring alert
+// alertSpec defines a monitoring alert.
+//
+// The alert is either defined by a list of rule groups or, for backwards
+// compatibility, by a single alerting rule.
 type alertSpec struct {
 	// GroupName is the alerting rule group name.
 	GroupName string `json:"group_name" yaml:"group_name"`
@@ -259,11 +504,177 @@ type alertSpec struct {
 	// Formula specifies the alert formula
 	Formula string `json:"formula" yaml:"formula"`
 	// Delay is an optional delay before alert triggers
-	Delay time.Duration `json:"duration" yaml:"duration"`
+	Delay duration `json:"duration" yaml:"duration"`
 	// Labels is the alerting rule labels.
 	Labels map[string]string `json:"labels"`
 	// Annotations is the alerting rule annotations.
 	Annotations map[string]string `json:"annotations"`
+	// Groups is the list of alerting rule groups.
+	Groups []alertGroupSpec `json:"groups,omitempty" yaml:"groups,omitempty"`
+}
+
+// alertGroupSpec defines a group of alerting rules
+type alertGroupSpec struct {
+	// Name is the group name
+	Name string `json:"name" yaml:"name"`
+	// Interval is an optional group evaluation interval
+	Interval duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+	// PartialResponseStrategy is an optional Thanos partial response strategy
+	PartialResponseStrategy string `json:"partial_response_strategy,omitempty" yaml:"partial_response_strategy,omitempty"`
+	// Rules is the list of alerting rules
+	Rules []alertRuleSpec `json:"rules" yaml:"rules"`
+}
+
+// alertRuleSpec defines an alerting rule
+type alertRuleSpec struct {
+	// Alert is the alert name
+	Alert string `json:"alert" yaml:"alert"`
+	// Expr is the alert expression
+	Expr string `json:"expr" yaml:"expr"`
+	// For is an optional duration the expression has to hold before the alert fires
+	For duration `json:"for,omitempty" yaml:"for,omitempty"`
+	// Labels is the alerting rule labels
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// Annotations is the alerting rule annotations
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+}
+
+// resource returns the monitoring alert this resource describes.
+func (a alert) resource() (*resources.Alert, error) {
+	if a.Name == "" {
+		return nil, trace.BadParameter("alert name is required")
+	}
+	if len(a.Spec.Groups) == 0 {
+		if a.Spec.Formula == "" {
+			return nil, trace.BadParameter("alert %v: either formula or groups is required", a.Name)
+		}
+		return &resources.Alert{
+			CRDName:     a.Name,
+			AlertName:   a.Spec.AlertName,
+			GroupName:   a.Spec.GroupName,
+			Formula:     a.Spec.Formula,
+			Delay:       time.Duration(a.Spec.Delay),
+			Labels:      a.Spec.Labels,
+			Annotations: a.Spec.Annotations,
+		}, nil
+	}
+	if a.Spec.Formula != "" || a.Spec.AlertName != "" || a.Spec.GroupName != "" {
+		return nil, trace.BadParameter("alert %v: groups cannot be combined with single rule fields", a.Name)
+	}
+	result := &resources.Alert{CRDName: a.Name}
+	groupNames := make(map[string]struct{})
+	for i, group := range a.Spec.Groups {
+		if group.Name == "" {
+			return nil, trace.BadParameter("alert %v: group %v: name is required", a.Name, i)
+		}
+		if _, ok := groupNames[group.Name]; ok {
+			return nil, trace.BadParameter("alert %v: duplicate group %v", a.Name, group.Name)
+		}
+		groupNames[group.Name] = struct{}{}
+		if len(group.Rules) == 0 {
+			return nil, trace.BadParameter("alert %v: group %v: at least one rule is required", a.Name, group.Name)
+		}
+		if err := checkPartialResponseStrategy(group.PartialResponseStrategy); err != nil {
+			return nil, trace.BadParameter("alert %v: group %v: %v", a.Name, group.Name, err)
+		}
+		ruleGroup := resources.RuleGroup{
+			Name:                    group.Name,
+			Interval:                time.Duration(group.Interval),
+			PartialResponseStrategy: group.PartialResponseStrategy,
+		}
+		for j, rule := range group.Rules {
+			if rule.Alert == "" {
+				return nil, trace.BadParameter("alert %v: group %v: rule %v: alert name is required", a.Name, group.Name, j)
+			}
+			if rule.Expr == "" {
+				return nil, trace.BadParameter("alert %v: group %v: rule %v: expr is required", a.Name, group.Name, rule.Alert)
+			}
+			ruleGroup.Rules = append(ruleGroup.Rules, resources.Rule{
+				Alert:       rule.Alert,
+				Expr:        rule.Expr,
+				For:         time.Duration(rule.For),
+				Labels:      rule.Labels,
+				Annotations: rule.Annotations,
+			})
+		}
+		result.Groups = append(result.Groups, ruleGroup)
+	}
+	return result, nil
+}
+
+// checkPartialResponseStrategy returns an error if the Thanos partial response
+// strategy is set to a value other than warn or abort.
+func checkPartialResponseStrategy(strategy string) error {
+	switch strategy {
+	case "", partialResponseWarn, partialResponseAbort:
+		return nil
+	}
+	return trace.BadParameter("partial_response_strategy must be %v or %v, got %q",
+		partialResponseWarn, partialResponseAbort, strategy)
+}
+
+// duration is a duration that can be specified either in Prometheus
+// duration format (e.g. "5m") or as a number of nanoseconds.
+type duration time.Duration
+
+// UnmarshalJSON parses the duration from JSON.
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return trace.Wrap(err)
+	}
+	switch v := value.(type) {
+	case nil:
+		*d = 0
+	case float64:
+		*d = duration(v)
+	case string:
+		if v == "" {
+			*d = 0
+			return nil
+		}
+		parsed, err := model.ParseDuration(v)
+		if err != nil {
+			return trace.BadParameter("invalid duration %q: %v", v, err)
+		}
+		*d = duration(parsed)
+	default:
+		return trace.BadParameter("invalid duration %s", data)
+	}
+	return nil
+}
+
+// MarshalJSON formats the duration in Prometheus duration format.
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(model.Duration(d).String())
+}
+
+// smtpSpecHash returns the hash of the provided SMTP configuration spec.
+//
+// The hash is only stored on the secret holding the spec itself.
+func smtpSpecHash(spec []byte) string {
+	hash := sha256.Sum256(spec)
+	return hex.EncodeToString(hash[:])
+}
+
+// setSMTPTestMessageAnnotation records the hash of the SMTP configuration
+// spec the test message has been sent for on the specified secret.
+func setSMTPTestMessageAnnotation(ctx context.Context, secrets corev1.SecretInterface, name, hash string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				constants.SMTPTestMessageAnnotation: hash,
+			},
+		},
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	_, err = secrets.Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return trace.Wrap(rigging.ConvertError(err))
+	}
+	return nil
 }
 
 // smtpConfigSpec defines a SMTP configuration
@@ -276,6 +687,70 @@ type smtpConfigSpec struct {
 	Username string `json:"username" yaml:"username"`
 	// Password specifies the password to connect
 	Password string `json:"password" yaml:"password"`
+	// From specifies the sender's email address.
+	// Defaults to constants.AlertFrom if unspecified
+	From string `json:"from,omitempty" yaml:"from,omitempty"`
+	// Hello specifies the hostname to send in EHLO/HELO
+	Hello string `json:"hello,omitempty" yaml:"hello,omitempty"`
+	// RequireTLS specifies whether STARTTLS is required
+	RequireTLS *bool `json:"require_tls,omitempty" yaml:"require_tls,omitempty"`
+	// AuthIdentity specifies the identity for PLAIN authentication
+	AuthIdentity string `json:"auth_identity,omitempty" yaml:"auth_identity,omitempty"`
+	// AuthSecret specifies the secret for CRAM-MD5 authentication
+	AuthSecret string `json:"auth_secret,omitempty" yaml:"auth_secret,omitempty"`
+	// TLS specifies the TLS settings used to connect to the SMTP service
+	TLS *smtpTLSSpec `json:"tls,omitempty" yaml:"tls,omitempty"`
+	// SendTestMessage specifies whether to send a test message to the
+	// configured alert targets after the configuration has been updated
+	SendTestMessage bool `json:"send_test_message,omitempty" yaml:"send_test_message,omitempty"`
+}
+
+// smtpConfig returns the SMTP configuration this spec describes.
+func (s smtpConfigSpec) smtpConfig() resources.SMTPConfig {
+	config := resources.SMTPConfig{
+		Host:         s.Host,
+		Port:         s.Port,
+		Username:     s.Username,
+		Password:     s.Password,
+		From:         s.From,
+		Hello:        s.Hello,
+		RequireTLS:   s.RequireTLS,
+		AuthIdentity: s.AuthIdentity,
+		AuthSecret:   s.AuthSecret,
+	}
+	if s.TLS != nil {
+		config.TLS = commoncfg.TLSConfig{
+			CAFile:             s.TLS.CAFile,
+			CertFile:           s.TLS.CertFile,
+			KeyFile:            s.TLS.KeyFile,
+			ServerName:         s.TLS.ServerName,
+			InsecureSkipVerify: s.TLS.InsecureSkipVerify,
+		}
+	}
+	return config
+}
+
+// smtpTLSSpec defines the TLS settings of a SMTP configuration
+type smtpTLSSpec struct {
+	// CAFile specifies the path to the CA certificate
+	CAFile string `json:"ca_file,omitempty" yaml:"ca_file,omitempty"`
+	// CertFile specifies the path to the client certificate
+	CertFile string `json:"cert_file,omitempty" yaml:"cert_file,omitempty"`
+	// KeyFile specifies the path to the client key
+	KeyFile string `json:"key_file,omitempty" yaml:"key_file,omitempty"`
+	// ServerName specifies the name used to verify the server certificate
+	ServerName string `json:"server_name,omitempty" yaml:"server_name,omitempty"`
+	// InsecureSkipVerify disables server certificate verification
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty" yaml:"insecure_skip_verify,omitempty"`
+}
+
+// resourceWarning is a problem found when applying a resource that does not
+// prevent it from being applied.
+type resourceWarning struct {
+	// reason is the event reason.
+	reason string
+	// message is the warning message.
+	message string
 }
 
 // alertTargetSpec defines a monitoring alert target
@@ -283,3 +758,26 @@ type alertTargetSpec struct {
 	// Email specifies the recipient's email
 	Email string `json:"email" yaml:"email"`
 }
+
+const (
+	// resourceSyncedReason is the event reason for a successfully applied resource.
+	resourceSyncedReason = "Synced"
+	// resourceSyncFailedReason is the event reason for a resource that failed to apply.
+	resourceSyncFailedReason = "SyncFailed"
+	// resourceConflictReason is the event reason for a resource with rules
+	// conflicting with existing rules.
+	resourceConflictReason = "Conflict"
+	// resourceLintReason is the event reason for a resource violating the
+	// alert lint policy.
+	resourceLintReason = "LintViolation"
+	// smtpCheckSucceededReason is the event reason for a successful SMTP check.
+	smtpCheckSucceededReason = "SMTPCheckSucceeded"
+	// smtpCheckFailedReason is the event reason for a failed SMTP check.
+	smtpCheckFailedReason = "SMTPCheckFailed"
+	// partialResponseWarn is the Thanos partial response strategy that
+	// evaluates rules with partial data and logs a warning.
+	partialResponseWarn = "warn"
+	// partialResponseAbort is the Thanos partial response strategy that
+	// fails rule evaluation when data is partial.
+	partialResponseAbort = "abort"
+)
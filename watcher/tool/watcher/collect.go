@@ -0,0 +1,180 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"path"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+	"github.com/gravitational/monitoring-app/watcher/lib/grafana"
+	"github.com/gravitational/monitoring-app/watcher/lib/resources"
+
+	"github.com/ghodss/yaml"
+	"github.com/gravitational/rigging"
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// collectRules deletes PrometheusRules created by the watcher whose source
+// ConfigMaps no longer exist or no longer define them.
+//
+// Such rules are left behind if a ConfigMap is deleted while the watcher is
+// not running or if the ConfigMap is updated with a different rules name.
+func collectRules(ctx context.Context, client resources.Resources, configMaps corev1.ConfigMapInterface) {
+	rules, err := client.GetManagedRules()
+	if err != nil {
+		log.WithError(err).Warn("Failed to query managed rules.")
+		return
+	}
+	for _, rule := range rules {
+		if rule.Owner == nil {
+			continue
+		}
+		log := log.WithField("rule", rule.Name)
+		orphaned, err := isOrphanedRule(ctx, configMaps, rule.Name, *rule.Owner)
+		if err != nil {
+			log.WithError(err).Warn("Failed to check rule origin.")
+			continue
+		}
+		if !orphaned {
+			continue
+		}
+		err = client.DeleteManagedRule(rule.Name)
+		if err != nil && !trace.IsNotFound(err) {
+			log.WithError(err).Warn("Failed to delete orphaned rule.")
+		}
+	}
+}
+
+// isOrphanedRule returns true if the ConfigMap the rule with the specified
+// name has been created from no longer defines it.
+func isOrphanedRule(ctx context.Context, configMaps corev1.ConfigMapInterface, name string, owner metav1.OwnerReference) (bool, error) {
+	configMap, err := configMaps.Get(ctx, owner.Name, metav1.GetOptions{})
+	if err != nil {
+		err = rigging.ConvertError(err)
+		if trace.IsNotFound(err) {
+			return true, nil
+		}
+		return false, trace.Wrap(err)
+	}
+	var ruleName string
+	switch configMap.Labels[constants.MonitoringLabel] {
+	case constants.MonitoringUpdateAlert:
+		ruleName, err = alertConfigMapName(configMap.Name, configMap.Data)
+	case constants.MonitoringUpdateRecordingRule:
+		var rules recordingRules
+		err = yaml.Unmarshal([]byte(configMap.Data[constants.ResourceSpecKey]), &rules)
+		ruleName = rules.Name
+	case constants.MonitoringUpdateSLO:
+		var s slo
+		err = yaml.Unmarshal([]byte(configMap.Data[constants.ResourceSpecKey]), &s)
+		ruleName = s.Name
+	default:
+		// The ConfigMap no longer describes rules.
+		return true, nil
+	}
+	if err != nil || ruleName == "" {
+		// Keep the rule until the ConfigMap spec is fixed.
+		return false, nil
+	}
+	return ruleName != name, nil
+}
+
+// collectDashboards deletes dashboards created by the watcher whose source
+// ConfigMaps no longer exist or no longer define them and the folders
+// created by the watcher that are left empty.
+//
+// Such dashboards are left behind if a ConfigMap is deleted while the watcher
+// is not running or if the dashboard uid is changed.
+func collectDashboards(ctx context.Context, client *grafana.Client, configMaps corev1.ConfigMapsGetter, clusterName string) {
+	dashboards, err := client.GetManagedDashboards(ctx)
+	if err != nil {
+		log.WithError(err).Warn("Failed to query managed dashboards.")
+		return
+	}
+	for _, dashboard := range dashboards {
+		log := log.WithField("dashboard", dashboard.Title)
+		orphaned, err := isOrphanedDashboard(ctx, configMaps.ConfigMaps(dashboard.Origin.Namespace), dashboard, clusterName)
+		if err != nil {
+			log.WithError(err).Warn("Failed to check dashboard origin.")
+			continue
+		}
+		if !orphaned {
+			continue
+		}
+		log.Info("Deleting orphaned dashboard.")
+		err = client.DeleteDashboardByUID(ctx, dashboard.UID)
+		if err != nil && !trace.IsNotFound(err) {
+			log.WithError(err).Warn("Failed to delete orphaned dashboard.")
+		}
+	}
+	if err := client.DeleteEmptyFolders(ctx); err != nil {
+		log.WithError(err).Warn("Failed to delete empty folders.")
+	}
+}
+
+// isOrphanedDashboard returns true if the ConfigMap key the dashboard has
+// been created from no longer defines it.
+func isOrphanedDashboard(ctx context.Context, configMaps corev1.ConfigMapInterface, dashboard grafana.ManagedDashboard, clusterName string) (bool, error) {
+	configMap, err := configMaps.Get(ctx, dashboard.Origin.Name, metav1.GetOptions{})
+	if err != nil {
+		err = rigging.ConvertError(err)
+		if trace.IsNotFound(err) {
+			return true, nil
+		}
+		return false, trace.Wrap(err)
+	}
+	data, ok := configMap.Data[dashboard.Origin.Key]
+	if !ok {
+		return true, nil
+	}
+	switch configMap.Labels[constants.MonitoringLabel] {
+	case constants.MonitoringUpdateDashboard:
+		if path.Ext(dashboard.Origin.Key) == jsonnetLibraryExt {
+			return true, nil
+		}
+		// Errors of other dashboards of the ConfigMap are irrelevant here.
+		dashboards, _ := dashboardsData(ctx, configMaps, configMap.Data, dashboardInputs(configMap.ObjectMeta, clusterName))
+		if data, ok = dashboards[dashboard.Origin.Key]; !ok {
+			// Keep the dashboard until the Jsonnet dashboard is fixed.
+			return false, nil
+		}
+	case constants.MonitoringUpdateSLO:
+		s, resourceSLO, err := parseSLO([]byte(data))
+		if err != nil {
+			// Keep the dashboard until the SLO spec is fixed.
+			return false, nil
+		}
+		if !s.Spec.Dashboard {
+			return true, nil
+		}
+		if data, err = sloDashboard(*resourceSLO); err != nil {
+			return false, trace.Wrap(err)
+		}
+	default:
+		return true, nil
+	}
+	uid, err := grafana.DashboardUID(data, dashboard.Origin)
+	if err != nil {
+		// Keep the dashboard until the ConfigMap data is fixed.
+		return false, nil
+	}
+	return uid != dashboard.UID, nil
+}
@@ -18,18 +18,27 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
 
 	"github.com/gravitational/monitoring-app/watcher/lib/constants"
 	"github.com/gravitational/monitoring-app/watcher/lib/grafana"
 	"github.com/gravitational/monitoring-app/watcher/lib/kubernetes"
 	"github.com/gravitational/monitoring-app/watcher/lib/utils"
 
+	"github.com/gravitational/rigging"
 	"github.com/gravitational/trace"
 	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/watch"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
-func runDashboardsWatcher(kubernetesClient *kubernetes.Client) error {
+func runDashboardsWatcher(kubernetesClient *kubernetes.Client, clusterName string) error {
 	grafanaClient, err := grafana.NewClient()
 	if err != nil {
 		return trace.Wrap(err)
@@ -45,40 +54,409 @@ func runDashboardsWatcher(kubernetesClient *kubernetes.Client) error {
 		return trace.Wrap(err)
 	}
 
+	sloLabel, err := kubernetes.MatchLabel(constants.MonitoringLabel, constants.MonitoringUpdateSLO)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	datasourceLabel, err := kubernetes.MatchLabel(constants.MonitoringLabel, constants.MonitoringUpdateDatasource)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	notifierLabel, err := kubernetes.MatchLabel(constants.MonitoringLabel, constants.MonitoringUpdateGrafanaNotifier)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	teamLabel, err := kubernetes.MatchLabel(constants.MonitoringLabel, constants.MonitoringUpdateGrafanaTeam)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	serviceAccountLabel, err := kubernetes.MatchLabel(constants.MonitoringLabel, constants.MonitoringUpdateGrafanaServiceAccount)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	permissionsLabel, err := kubernetes.MatchLabel(constants.MonitoringLabel, constants.MonitoringUpdateGrafanaPermissions)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	jsonnetLibraryLabel, err := kubernetes.MatchLabel(constants.MonitoringLabel, constants.MonitoringUpdateJsonnetLibrary)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	configMaps := kubernetesClient.CoreV1().ConfigMaps(constants.MonitoringNamespace)
+	secrets := kubernetesClient.CoreV1().Secrets(constants.MonitoringNamespace)
+
+	// Datasources are created before dashboards so the datasources dashboards
+	// refer to by name exist by the time the dashboards are created.
+	err = syncDatasources(context.TODO(), grafanaClient, configMaps, secrets)
+	if err != nil {
+		log.WithError(err).Warn("Failed to sync datasources.")
+	}
+
 	ch := make(chan kubernetes.ConfigMapUpdate)
-	go kubernetesClient.WatchConfigMaps(context.TODO(), kubernetes.ConfigMap{Selector: label, RecvCh: ch})
-	receiveAndCreateDashboards(context.TODO(), grafanaClient, ch)
+	sloCh := make(chan kubernetes.ConfigMapUpdate)
+	datasourceCh := make(chan kubernetes.ConfigMapUpdate)
+	datasourceSecretCh := make(chan kubernetes.SecretUpdate)
+	notifierCh := make(chan kubernetes.ConfigMapUpdate)
+	notifierSecretCh := make(chan kubernetes.SecretUpdate)
+	teamCh := make(chan kubernetes.ConfigMapUpdate)
+	serviceAccountCh := make(chan kubernetes.ConfigMapUpdate)
+	permissionsCh := make(chan kubernetes.ConfigMapUpdate)
+	jsonnetLibraryCh := make(chan kubernetes.ConfigMapUpdate)
+	go kubernetesClient.WatchConfigMaps(context.TODO(),
+		kubernetes.ConfigMap{Selector: label, RecvCh: ch},
+		kubernetes.ConfigMap{Selector: sloLabel, RecvCh: sloCh},
+		kubernetes.ConfigMap{Selector: datasourceLabel, RecvCh: datasourceCh},
+		kubernetes.ConfigMap{Selector: notifierLabel, RecvCh: notifierCh},
+		kubernetes.ConfigMap{Selector: teamLabel, RecvCh: teamCh},
+		kubernetes.ConfigMap{Selector: serviceAccountLabel, RecvCh: serviceAccountCh},
+		kubernetes.ConfigMap{Selector: permissionsLabel, RecvCh: permissionsCh},
+		kubernetes.ConfigMap{Selector: jsonnetLibraryLabel, RecvCh: jsonnetLibraryCh})
+	go kubernetesClient.WatchSecrets(context.TODO(),
+		kubernetes.Secret{Selector: datasourceLabel, RecvCh: datasourceSecretCh},
+		kubernetes.Secret{Selector: notifierLabel, RecvCh: notifierSecretCh})
+	go utils.RunPeriodically(context.TODO(), constants.GarbageCollectionInterval, func(ctx context.Context) {
+		collectDashboards(ctx, grafanaClient, kubernetesClient.CoreV1(), clusterName)
+	})
+	go receiveAndCreateSLODashboards(context.TODO(), grafanaClient, sloCh)
+	// datasourcesSyncedCh notifies the dashboard loop that datasources have
+	// been synced so dashboards with unresolved inputs are created again.
+	datasourcesSyncedCh := make(chan struct{}, 1)
+	go receiveAndSyncGrafanaResources(context.TODO(), constants.MonitoringUpdateDatasource, datasourceCh, datasourceSecretCh,
+		func(ctx context.Context) error {
+			err := syncDatasources(ctx, grafanaClient, configMaps, secrets)
+			select {
+			case datasourcesSyncedCh <- struct{}{}:
+			default:
+			}
+			return trace.Wrap(err)
+		})
+	go receiveAndSyncGrafanaResources(context.TODO(), constants.MonitoringUpdateGrafanaNotifier, notifierCh, notifierSecretCh,
+		func(ctx context.Context) error {
+			return syncNotifiers(ctx, grafanaClient, configMaps, secrets)
+		})
+	go receiveAndSyncAccess(context.TODO(), grafanaClient, configMaps, teamCh, serviceAccountCh, permissionsCh)
+	receiveAndCreateDashboards(context.TODO(), grafanaClient, kubernetesClient.CoreV1(),
+		kubernetesClient.CoreV1().Events(constants.MonitoringNamespace), clusterName, ch, jsonnetLibraryCh, datasourcesSyncedCh)
 	return nil
 }
 
+// receiveAndCreateSLODashboards listens on the provided channel that receives
+// SLO updates and maintains their dashboards in Grafana.
+//
+// Dashboards of SLOs with the dashboard disabled are removed by the
+// garbage collector.
+func receiveAndCreateSLODashboards(ctx context.Context, client *grafana.Client, ch <-chan kubernetes.ConfigMapUpdate) {
+	for {
+		select {
+		case update := <-ch:
+			log := log.WithField("configmap", update.ResourceUpdate.Meta())
+			s, resourceSLO, err := parseSLO([]byte(update.Data[constants.ResourceSpecKey]))
+			if err != nil {
+				log.Warnf("Failed to parse SLO: %v.", trace.DebugReport(err))
+				continue
+			}
+			if !s.Spec.Dashboard {
+				continue
+			}
+			dashboard, err := sloDashboard(*resourceSLO)
+			if err != nil {
+				log.Warnf("Failed to generate SLO dashboard: %v.", trace.DebugReport(err))
+				continue
+			}
+			origin := grafana.Origin{
+				Namespace: update.Namespace,
+				Name:      update.Name,
+				Key:       constants.ResourceSpecKey,
+			}
+			switch update.EventType {
+			case watch.Added, watch.Modified:
+				if err := client.CreateDashboard(ctx, dashboard, origin, dashboardFolder(update.ObjectMeta)); err != nil {
+					log.Errorf("failed to create SLO dashboard: %v", trace.DebugReport(err))
+				}
+			case watch.Deleted:
+				if err := client.DeleteDashboard(ctx, dashboard, origin); err != nil {
+					log.Errorf("failed to delete SLO dashboard: %v", trace.DebugReport(err))
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 // receiveAndCreateDashboards listens on the provided channel that receives new dashboards data and creates
 // them in Grafana using the provided client
-func receiveAndCreateDashboards(ctx context.Context, client *grafana.Client, ch <-chan kubernetes.ConfigMapUpdate) {
+//
+// The uids of the dashboards created from each ConfigMap are recorded in the ConfigMap annotation
+// so the dashboards of keys removed from the ConfigMap are deleted once the ConfigMap is modified.
+//
+// The inputs of exported dashboards are substituted with the values from the ConfigMap annotation
+// and the specified cluster name. Jsonnet dashboards are evaluated with the same values as external
+// variables and are evaluated again when the Jsonnet libraries received on the library channel change.
+//
+// Dashboards whose inputs cannot be resolved, e.g. because the datasource does not exist yet, are
+// created again each time datasources are synced, as signaled on the datasource channel.
+func receiveAndCreateDashboards(ctx context.Context, client *grafana.Client, configMaps corev1.ConfigMapsGetter,
+	events corev1.EventInterface, clusterName string, ch, libraryCh <-chan kubernetes.ConfigMapUpdate,
+	datasourceCh <-chan struct{}) {
+	// unresolved is the set of names of ConfigMaps with dashboards whose inputs could not be resolved
+	unresolved := make(map[string]struct{})
 	for {
 		select {
 		case update := <-ch:
 			switch update.EventType {
 			case watch.Added, watch.Modified:
-				log := log.WithField("configmap", update.ResourceUpdate.Meta())
-				for _, dashboard := range update.Data {
-					err := client.CreateDashboard(ctx, dashboard)
-
-					if err != nil {
-						log.Errorf("failed to create dashboard %v: %v", dashboard, trace.DebugReport(err))
-					}
+				if createDashboards(ctx, client, configMaps, events, clusterName, update) {
+					unresolved[update.Name] = struct{}{}
+				} else {
+					delete(unresolved, update.Name)
 				}
 			case watch.Deleted:
+				delete(unresolved, update.Name)
 				log := log.WithField("configmap", update.ResourceUpdate.Meta())
-				for _, dashboard := range update.Data {
-					err := client.DeleteDashboard(ctx, dashboard)
+				dashboards, err := dashboardsData(ctx, configMaps.ConfigMaps(update.Namespace), update.Data,
+					dashboardInputs(update.ObjectMeta, clusterName))
+				if err != nil {
+					// The dashboards are deleted by the garbage collector.
+					log.Warnf("Failed to evaluate dashboards: %v.", trace.DebugReport(err))
+				}
+				for key, dashboard := range dashboards {
+					err := client.DeleteDashboard(ctx, dashboard, grafana.Origin{
+						Namespace: update.Namespace,
+						Name:      update.Name,
+						Key:       key,
+					})
 
 					if err != nil {
 						log.Errorf("failed to delete dashboard %v: %v", dashboard, trace.DebugReport(err))
 					}
 				}
 			}
+		case update := <-libraryCh:
+			log.Infof("Jsonnet library updated: %v.", update)
+			err := recreateDashboards(ctx, client, configMaps, events, clusterName, unresolved,
+				func(configMap v1.ConfigMap) bool {
+					return hasJsonnetDashboards(configMap.Data)
+				})
+			if err != nil {
+				log.Errorf("failed to recreate Jsonnet dashboards: %v", trace.DebugReport(err))
+			}
+		case <-datasourceCh:
+			if len(unresolved) == 0 {
+				continue
+			}
+			err := recreateDashboards(ctx, client, configMaps, events, clusterName, unresolved,
+				func(configMap v1.ConfigMap) bool {
+					_, ok := unresolved[configMap.Name]
+					return ok
+				})
+			if err != nil {
+				log.Errorf("failed to recreate dashboards with unresolved inputs: %v", trace.DebugReport(err))
+			}
 		case <-ctx.Done():
 			return
 		}
 	}
 }
+
+// createDashboards creates or updates the dashboards defined by the updated ConfigMap
+// and deletes the ones removed from it. It returns true if the inputs of some of the
+// dashboards could not be resolved.
+//
+// Jsonnet evaluation errors are recorded as events on the ConfigMap.
+func createDashboards(ctx context.Context, client *grafana.Client, configMaps corev1.ConfigMapsGetter,
+	events corev1.EventInterface, clusterName string, update kubernetes.ConfigMapUpdate) (unresolved bool) {
+	log := log.WithField("configmap", update.ResourceUpdate.Meta())
+	folder := dashboardFolder(update.ObjectMeta)
+	inputs := dashboardInputs(update.ObjectMeta, clusterName)
+	dashboards, evalErr := dashboardsData(ctx, configMaps.ConfigMaps(update.Namespace), update.Data, inputs)
+	if evalErr != nil {
+		log.Warnf("Failed to evaluate dashboards: %v.", trace.DebugReport(evalErr))
+		err := kubernetes.RecordEvent(ctx, events, update.ObjectReference(kubernetes.KindConfigMap),
+			v1.EventTypeWarning, dashboardEvaluationFailedReason,
+			fmt.Sprintf("Failed to evaluate dashboards: %v.", trace.UserMessage(evalErr)))
+		if err != nil {
+			log.WithError(err).Warn("Failed to record dashboard evaluation event.")
+		}
+	}
+	for key, dashboard := range dashboards {
+		dashboard, err := client.ResolveInputs(ctx, dashboard, inputs)
+		if err != nil {
+			log.Errorf("failed to resolve inputs of dashboard %v: %v", key, trace.DebugReport(err))
+			unresolved = true
+			continue
+		}
+		err = client.CreateDashboard(ctx, dashboard, grafana.Origin{
+			Namespace: update.Namespace,
+			Name:      update.Name,
+			Key:       key,
+		}, folder)
+
+		if err != nil {
+			log.Errorf("failed to create dashboard %v: %v", dashboard, trace.DebugReport(err))
+		}
+	}
+	if evalErr != nil {
+		// The uids of the dashboards that failed to evaluate are unknown.
+		return unresolved
+	}
+	err := syncRemovedDashboards(ctx, client, configMaps.ConfigMaps(update.Namespace), update, dashboards)
+	if err != nil {
+		log.Errorf("failed to delete removed dashboards: %v", trace.DebugReport(err))
+	}
+	return unresolved
+}
+
+// recreateDashboards creates or updates the dashboards of the dashboard ConfigMaps
+// selected by the provided function and updates the provided set of names of
+// ConfigMaps with dashboards whose inputs could not be resolved.
+func recreateDashboards(ctx context.Context, client *grafana.Client, configMaps corev1.ConfigMapsGetter,
+	events corev1.EventInterface, clusterName string, unresolved map[string]struct{}, include func(v1.ConfigMap) bool) error {
+	list, err := configMaps.ConfigMaps(constants.MonitoringNamespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%v=%v", constants.MonitoringLabel, constants.MonitoringUpdateDashboard),
+	})
+	if err != nil {
+		return trace.Wrap(rigging.ConvertError(err))
+	}
+	for _, configMap := range list.Items {
+		if !include(configMap) {
+			continue
+		}
+		if createDashboards(ctx, client, configMaps, events, clusterName, kubernetes.ConfigMapUpdate{
+			ResourceUpdate: kubernetes.ResourceUpdate{
+				EventType:  watch.Modified,
+				TypeMeta:   configMap.TypeMeta,
+				ObjectMeta: configMap.ObjectMeta,
+			},
+			Data: configMap.Data,
+		}) {
+			unresolved[configMap.Name] = struct{}{}
+		} else {
+			delete(unresolved, configMap.Name)
+		}
+	}
+	return nil
+}
+
+// dashboardFolder returns the title of the Grafana folder for the dashboards
+// created from the ConfigMap with the specified metadata.
+//
+// The folder is set with the folder annotation, an empty value places the dashboards
+// in the General folder. By default the dashboards are placed in the folder named after
+// the application label of the ConfigMap or, if the label is not set, after its namespace.
+func dashboardFolder(meta metav1.ObjectMeta) string {
+	if folder, ok := meta.Annotations[constants.DashboardFolderAnnotation]; ok {
+		return folder
+	}
+	if app := meta.Labels[constants.AppLabel]; app != "" {
+		return app
+	}
+	return meta.Namespace
+}
+
+// dashboardInputs returns the values of the inputs of exported dashboards
+// created from the ConfigMap with the specified metadata.
+//
+// The cluster name and the ConfigMap namespace are provided as the values of
+// the inputs with the respective names unless the inputs annotation sets them.
+func dashboardInputs(meta metav1.ObjectMeta, clusterName string) map[string]string {
+	inputs := map[string]string{
+		constants.DashboardInputNamespace: meta.Namespace,
+	}
+	if clusterName != "" {
+		inputs[constants.DashboardInputClusterName] = clusterName
+	}
+	if annotation, ok := meta.Annotations[constants.DashboardInputsAnnotation]; ok {
+		var values map[string]string
+		if err := json.Unmarshal([]byte(annotation), &values); err != nil {
+			log.Warnf("Ignoring invalid %v annotation on ConfigMap %v: %v.",
+				constants.DashboardInputsAnnotation, meta.Name, err)
+		}
+		for name, value := range values {
+			inputs[name] = value
+		}
+	}
+	return inputs
+}
+
+// syncRemovedDashboards deletes the dashboards recorded in the ConfigMap annotation
+// that the provided dashboards of the ConfigMap no longer include and records the
+// uids of the current ones.
+//
+// If the uid of any dashboard cannot be determined, nothing is deleted as the
+// dashboard might be one of the recorded ones.
+func syncRemovedDashboards(ctx context.Context, client *grafana.Client, configMaps corev1.ConfigMapInterface,
+	update kubernetes.ConfigMapUpdate, dashboards map[string]string) error {
+	uids := make([]string, 0, len(dashboards))
+	for key, dashboard := range dashboards {
+		uid, err := grafana.DashboardUID(dashboard, grafana.Origin{
+			Namespace: update.Namespace,
+			Name:      update.Name,
+			Key:       key,
+		})
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+
+	var recorded []string
+	if annotation, ok := update.Annotations[constants.DashboardsAnnotation]; ok {
+		if err := json.Unmarshal([]byte(annotation), &recorded); err != nil {
+			log.Warnf("Ignoring invalid %v annotation on ConfigMap %v: %v.",
+				constants.DashboardsAnnotation, update.Name, err)
+		}
+	}
+
+	current := make(map[string]struct{}, len(uids))
+	for _, uid := range uids {
+		current[uid] = struct{}{}
+	}
+	for _, uid := range recorded {
+		if _, ok := current[uid]; ok {
+			continue
+		}
+		log.Infof("Deleting dashboard %v removed from ConfigMap %v.", uid, update.Name)
+		err := client.DeleteDashboardByUID(ctx, uid)
+		if err != nil && !trace.IsNotFound(err) {
+			return trace.Wrap(err)
+		}
+	}
+
+	if reflect.DeepEqual(recorded, uids) {
+		return nil
+	}
+	return trace.Wrap(setDashboardsAnnotation(ctx, configMaps, update.Name, uids))
+}
+
+// setDashboardsAnnotation records the uids of the dashboards created from the
+// specified ConfigMap in its annotation.
+func setDashboardsAnnotation(ctx context.Context, configMaps corev1.ConfigMapInterface, name string, uids []string) error {
+	value, err := json.Marshal(uids)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{
+				constants.DashboardsAnnotation: string(value),
+			},
+		},
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	_, err = configMaps.Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return trace.Wrap(rigging.ConvertError(err), "failed to annotate ConfigMap %v", name)
+	}
+	return nil
+}
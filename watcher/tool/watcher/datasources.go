@@ -0,0 +1,169 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+	"github.com/gravitational/monitoring-app/watcher/lib/grafana"
+
+	"github.com/ghodss/yaml"
+	"github.com/gravitational/trace"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// syncDatasources creates or updates Grafana datasources defined by datasource
+// ConfigMaps and Secrets and deletes the datasources created by the watcher
+// whose resources no longer exist.
+//
+// Datasources defined by invalid resources are left as they are until the
+// resource is fixed.
+func syncDatasources(ctx context.Context, client *grafana.Client, configMaps corev1.ConfigMapInterface, secrets corev1.SecretInterface) error {
+	sources, err := listGrafanaResources(ctx, configMaps, secrets, constants.MonitoringUpdateDatasource)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	var errors []error
+	// names is the set of names of datasources defined by resources
+	names := make(map[string]struct{})
+	for _, source := range sources {
+		datasource, err := parseDatasource(ctx, source.origin.Kind, source.spec, secrets)
+		if err == nil {
+			if _, ok := names[datasource.Name]; ok {
+				err = trace.AlreadyExists("datasource %q is defined by multiple resources", datasource.Name)
+			}
+		}
+		if err != nil {
+			errors = append(errors, trace.Wrap(err, "%v %v", source.origin.Kind, source.origin.Name))
+			var resource datasourceResource
+			if yaml.Unmarshal(source.spec, &resource) == nil && resource.Name != "" {
+				names[resource.Name] = struct{}{}
+			}
+			continue
+		}
+		names[datasource.Name] = struct{}{}
+		if err := client.UpsertDatasource(ctx, *datasource, source.origin); err != nil {
+			errors = append(errors, trace.Wrap(err, "%v %v", source.origin.Kind, source.origin.Name))
+		}
+	}
+
+	managed, err := client.GetManagedDatasources(ctx)
+	if err != nil {
+		return trace.NewAggregate(append(errors, trace.Wrap(err))...)
+	}
+	for _, datasource := range managed {
+		if _, ok := names[datasource.Name]; ok {
+			continue
+		}
+		err := client.DeleteDatasource(ctx, datasource.Name)
+		if err != nil && !trace.IsNotFound(err) {
+			errors = append(errors, trace.Wrap(err, "datasource %q", datasource.Name))
+		}
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// parseDatasource parses and validates the datasource resource from the
+// provided spec of a resource of the specified kind.
+//
+// Secure JSON data can only be set inline in Secrets, ConfigMaps have to
+// reference a Secret with it.
+func parseDatasource(ctx context.Context, kind string, spec []byte, secrets corev1.SecretInterface) (*grafana.Datasource, error) {
+	if len(bytes.TrimSpace(spec)) == 0 {
+		return nil, trace.NotFound("empty configuration")
+	}
+
+	// The spec is not included in errors as it might contain credentials.
+	var resource datasourceResource
+	if err := yaml.Unmarshal(spec, &resource); err != nil {
+		return nil, trace.Wrap(err, "failed to unmarshal datasource")
+	}
+	if resource.Name == "" {
+		return nil, trace.BadParameter("datasource name is required")
+	}
+	if resource.Spec.Type == "" {
+		return nil, trace.BadParameter("datasource %q: type is required", resource.Name)
+	}
+	secureJSONData, err := secureData(ctx, secrets, kind, resource.Spec.SecureJSONDataSecret, resource.Spec.SecureJSONData)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	datasource := &grafana.Datasource{
+		UID:            resource.Spec.UID,
+		Name:           resource.Name,
+		Type:           resource.Spec.Type,
+		Access:         resource.Spec.Access,
+		URL:            resource.Spec.URL,
+		Database:       resource.Spec.Database,
+		User:           resource.Spec.User,
+		BasicAuth:      resource.Spec.BasicAuth,
+		BasicAuthUser:  resource.Spec.BasicAuthUser,
+		IsDefault:      resource.Spec.IsDefault,
+		JSONData:       resource.Spec.JSONData,
+		SecureJSONData: secureJSONData,
+	}
+	if datasource.Access == "" {
+		datasource.Access = defaultDatasourceAccess
+	}
+	return datasource, nil
+}
+
+// datasourceResource defines the Grafana datasource resource
+type datasourceResource struct {
+	// Metadata is the resource metadata, the name is the datasource name
+	Metadata `json:"metadata" yaml:"metadata"`
+	// Spec defines the datasource
+	Spec datasourceSpec `json:"spec" yaml:"spec"`
+}
+
+// datasourceSpec defines a Grafana datasource
+type datasourceSpec struct {
+	// Type is the datasource type, e.g. prometheus, loki or postgres
+	Type string `json:"type" yaml:"type"`
+	// UID is the optional datasource uid
+	UID string `json:"uid,omitempty" yaml:"uid,omitempty"`
+	// URL is the datasource URL
+	URL string `json:"url,omitempty" yaml:"url,omitempty"`
+	// Access is the datasource access mode, proxy by default
+	Access string `json:"access,omitempty" yaml:"access,omitempty"`
+	// Database is the datasource database name
+	Database string `json:"database,omitempty" yaml:"database,omitempty"`
+	// User is the datasource user name
+	User string `json:"user,omitempty" yaml:"user,omitempty"`
+	// BasicAuth is whether basic authentication is enabled
+	BasicAuth bool `json:"basicAuth,omitempty" yaml:"basicAuth,omitempty"`
+	// BasicAuthUser is the basic authentication user name
+	BasicAuthUser string `json:"basicAuthUser,omitempty" yaml:"basicAuthUser,omitempty"`
+	// IsDefault is whether the datasource is the default one
+	IsDefault bool `json:"isDefault,omitempty" yaml:"isDefault,omitempty"`
+	// JSONData is the datasource type specific settings
+	JSONData map[string]interface{} `json:"jsonData,omitempty" yaml:"jsonData,omitempty"`
+	// SecureJSONData is the datasource type specific secure settings, only allowed in Secrets
+	SecureJSONData map[string]string `json:"secureJsonData,omitempty" yaml:"secureJsonData,omitempty"`
+	// SecureJSONDataSecret is the name of the Secret in the monitoring namespace
+	// whose keys are set as secure settings
+	SecureJSONDataSecret string `json:"secureJsonDataSecret,omitempty" yaml:"secureJsonDataSecret,omitempty"`
+}
+
+const (
+	// defaultDatasourceAccess is the default datasource access mode.
+	defaultDatasourceAccess = "proxy"
+)
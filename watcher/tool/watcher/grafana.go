@@ -0,0 +1,146 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+	"github.com/gravitational/monitoring-app/watcher/lib/grafana"
+	"github.com/gravitational/monitoring-app/watcher/lib/kubernetes"
+
+	"github.com/gravitational/rigging"
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// receiveAndSyncGrafanaResources listens on the provided channels that receive
+// updates of Grafana resources of the specified kind and syncs all resources
+// of the kind with the provided function.
+//
+// Resources are also synced periodically so changes to the Secrets they
+// reference are picked up.
+func receiveAndSyncGrafanaResources(ctx context.Context, kind string, configMapCh <-chan kubernetes.ConfigMapUpdate,
+	secretCh <-chan kubernetes.SecretUpdate, sync func(context.Context) error) {
+	ticker := time.NewTicker(constants.GarbageCollectionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case update := <-configMapCh:
+			log.Infof("Grafana %v updated: %v.", kind, update)
+		case update := <-secretCh:
+			log.Infof("Grafana %v updated: %v.", kind, update)
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := sync(ctx); err != nil {
+			log.Warnf("Failed to sync Grafana %vs: %v.", kind, trace.DebugReport(err))
+		}
+	}
+}
+
+// grafanaResource is the spec of a Grafana resource along with the ConfigMap
+// or Secret it comes from
+type grafanaResource struct {
+	// origin references the ConfigMap or Secret
+	origin grafana.Origin
+	// spec is the resource spec
+	spec []byte
+}
+
+// listGrafanaResources returns the specs of Grafana resources defined by
+// ConfigMaps and Secrets with the specified monitoring label value, sorted
+// by the kind and name of the ConfigMap or Secret. Secrets are not listed if
+// secrets is nil.
+func listGrafanaResources(ctx context.Context, configMaps corev1.ConfigMapInterface, secrets corev1.SecretInterface,
+	monitoringUpdate string) ([]grafanaResource, error) {
+	listOptions := metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%v=%v", constants.MonitoringLabel, monitoringUpdate),
+	}
+	configMapList, err := configMaps.List(ctx, listOptions)
+	if err != nil {
+		return nil, trace.Wrap(rigging.ConvertError(err))
+	}
+
+	var resources []grafanaResource
+	for _, configMap := range configMapList.Items {
+		resources = append(resources, grafanaResource{
+			origin: grafana.Origin{
+				Kind:      kubernetes.KindConfigMap,
+				Namespace: configMap.Namespace,
+				Name:      configMap.Name,
+				Key:       constants.ResourceSpecKey,
+			},
+			spec: []byte(configMap.Data[constants.ResourceSpecKey]),
+		})
+	}
+	if secrets != nil {
+		secretList, err := secrets.List(ctx, listOptions)
+		if err != nil {
+			return nil, trace.Wrap(rigging.ConvertError(err))
+		}
+		for _, secret := range secretList.Items {
+			resources = append(resources, grafanaResource{
+				origin: grafana.Origin{
+					Kind:      kubernetes.KindSecret,
+					Namespace: secret.Namespace,
+					Name:      secret.Name,
+					Key:       constants.ResourceSpecKey,
+				},
+				spec: secret.Data[constants.ResourceSpecKey],
+			})
+		}
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].origin.Kind != resources[j].origin.Kind {
+			return resources[i].origin.Kind < resources[j].origin.Kind
+		}
+		return resources[i].origin.Name < resources[j].origin.Name
+	})
+	return resources, nil
+}
+
+// secureData returns the data of the specified Secret merged with the
+// provided inline secure data.
+//
+// Inline secure data is only accepted from Secrets, resources defined by
+// ConfigMaps have to reference a Secret instead.
+func secureData(ctx context.Context, secrets corev1.SecretInterface, kind, secretName string, inline map[string]string) (map[string]string, error) {
+	if kind != kubernetes.KindSecret && len(inline) != 0 {
+		return nil, trace.BadParameter("secure settings can only be set inline in Secrets, reference a Secret instead")
+	}
+	data := make(map[string]string)
+	if secretName != "" {
+		secret, err := secrets.Get(ctx, secretName, metav1.GetOptions{})
+		if err != nil {
+			return nil, trace.Wrap(rigging.ConvertError(err))
+		}
+		for key, value := range secret.Data {
+			data[key] = string(value)
+		}
+	}
+	for key, value := range inline {
+		data[key] = value
+	}
+	return data, nil
+}
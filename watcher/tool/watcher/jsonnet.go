@@ -0,0 +1,132 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+
+	jsonnet "github.com/google/go-jsonnet"
+	"github.com/gravitational/rigging"
+	"github.com/gravitational/trace"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// dashboardsData returns the JSON data of the dashboards defined by the ConfigMap
+// data by key and the errors of the dashboards that failed to evaluate.
+//
+// Keys with the .jsonnet extension are evaluated with the provided external
+// variables and may import the .libsonnet keys of the same ConfigMap or the
+// keys of Jsonnet library ConfigMaps as <library ConfigMap name>/<key>.
+// Library keys do not define dashboards.
+func dashboardsData(ctx context.Context, configMaps corev1.ConfigMapInterface, data map[string]string, extVars map[string]string) (map[string]string, error) {
+	dashboards := make(map[string]string, len(data))
+	var importer *jsonnetImporter
+	var errors []error
+	for key, value := range data {
+		switch path.Ext(key) {
+		case jsonnetLibraryExt:
+			continue
+		case jsonnetExt:
+		default:
+			dashboards[key] = value
+			continue
+		}
+		if importer == nil {
+			var err error
+			if importer, err = newJsonnetImporter(ctx, configMaps, data); err != nil {
+				errors = append(errors, trace.Wrap(err, "failed to evaluate %v", key))
+				continue
+			}
+		}
+		vm := jsonnet.MakeVM()
+		vm.Importer(importer)
+		for name, value := range extVars {
+			vm.ExtVar(name, value)
+		}
+		dashboard, err := vm.EvaluateSnippet(key, value)
+		if err != nil {
+			errors = append(errors, trace.BadParameter("failed to evaluate %v: %v", key, err))
+			continue
+		}
+		dashboards[key] = dashboard
+	}
+	return dashboards, trace.NewAggregate(errors...)
+}
+
+// hasJsonnetDashboards returns true if the ConfigMap data defines Jsonnet dashboards.
+func hasJsonnetDashboards(data map[string]string) bool {
+	for key := range data {
+		if path.Ext(key) == jsonnetExt {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonnetImporter imports Jsonnet files from the dashboard ConfigMap and the
+// Jsonnet library ConfigMaps
+type jsonnetImporter struct {
+	// files is the contents of the importable files by path
+	files map[string]jsonnet.Contents
+}
+
+// newJsonnetImporter returns the importer of the keys of the provided dashboard
+// ConfigMap data and the Jsonnet library ConfigMaps.
+func newJsonnetImporter(ctx context.Context, configMaps corev1.ConfigMapInterface, data map[string]string) (*jsonnetImporter, error) {
+	libraries, err := configMaps.List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%v=%v", constants.MonitoringLabel, constants.MonitoringUpdateJsonnetLibrary),
+	})
+	if err != nil {
+		return nil, trace.Wrap(rigging.ConvertError(err))
+	}
+	importer := &jsonnetImporter{files: make(map[string]jsonnet.Contents)}
+	for _, library := range libraries.Items {
+		for key, value := range library.Data {
+			importer.files[path.Join(library.Name, key)] = jsonnet.MakeContents(value)
+		}
+	}
+	for key, value := range data {
+		importer.files[key] = jsonnet.MakeContents(value)
+	}
+	return importer, nil
+}
+
+// Import returns the contents of the file with the specified path relative
+// to the importing file or, if there is no such file, relative to the root.
+func (i *jsonnetImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	candidates := []string{path.Join(path.Dir(importedFrom), importedPath), path.Clean(importedPath)}
+	for _, candidate := range candidates {
+		if contents, ok := i.files[candidate]; ok {
+			return contents, candidate, nil
+		}
+	}
+	return jsonnet.Contents{}, "", trace.NotFound("import %q not found in the ConfigMap or Jsonnet libraries", importedPath)
+}
+
+const (
+	// jsonnetExt is the extension of ConfigMap keys with Jsonnet dashboards
+	jsonnetExt = ".jsonnet"
+	// jsonnetLibraryExt is the extension of ConfigMap keys with Jsonnet libraries
+	jsonnetLibraryExt = ".libsonnet"
+	// dashboardEvaluationFailedReason is the reason of events recorded when a Jsonnet dashboard fails to evaluate
+	dashboardEvaluationFailedReason = "EvaluationFailed"
+)
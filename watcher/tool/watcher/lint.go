@@ -0,0 +1,91 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+	"github.com/gravitational/monitoring-app/watcher/lib/lint"
+
+	"github.com/gravitational/trace"
+)
+
+// runLint checks alert ConfigMaps in the provided manifest files against
+// the alert lint policy.
+//
+// Violations only fail the command if the policy is enforced while
+// invalid alert specs always do.
+func runLint(args []string) error {
+	flags := flag.NewFlagSet(constants.CommandLint, flag.ExitOnError)
+	policyPath := flags.String("policy", "", "lint policy path, the recommended policy is used if not set")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: watcher %v [-policy path] manifest [manifest...]\n", constants.CommandLint)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return trace.Wrap(err)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return trace.BadParameter("at least one manifest file is required")
+	}
+
+	policy := lint.RecommendedPolicy()
+	if *policyPath != "" {
+		loaded, err := lint.LoadPolicy(*policyPath)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		policy = *loaded
+	}
+
+	var failed, violated int
+	for _, filename := range flags.Args() {
+		configMaps, err := manifestConfigMaps(filename)
+		if err != nil {
+			return trace.Wrap(err)
+		}
+		for _, configMap := range configMaps {
+			if configMap.Labels[constants.MonitoringLabel] != constants.MonitoringUpdateAlert {
+				continue
+			}
+			alert, err := parseAlertConfigMap(configMap.Name, configMap.Data)
+			if err != nil {
+				fmt.Printf("%v: ConfigMap %v: %v\n", filename, configMap.Name, trace.UserMessage(err))
+				failed++
+				continue
+			}
+			violations := policy.Lint(alert.RuleGroups())
+			for _, violation := range violations {
+				fmt.Printf("%v: ConfigMap %v: %v\n", filename, configMap.Name, violation)
+			}
+			if len(violations) != 0 {
+				violated++
+			}
+		}
+	}
+
+	if failed != 0 {
+		return trace.BadParameter("%v alert ConfigMaps are invalid", failed)
+	}
+	if violated != 0 && policy.Enforced() {
+		return trace.BadParameter("%v alert ConfigMaps violate the lint policy", violated)
+	}
+	return nil
+}
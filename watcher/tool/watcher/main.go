@@ -30,13 +30,15 @@ import (
 )
 
 var (
-	mode, kubeconfig string
-	debug            bool
+	mode, kubeconfig, lintPolicy, clusterName string
+	debug                                     bool
 )
 
 func main() {
 	flag.StringVar(&mode, "mode", "", fmt.Sprintf("watcher mode: %v", constants.AllModes))
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "optional kubeconfig path")
+	flag.StringVar(&lintPolicy, "lint-policy", "", "optional alert lint policy path")
+	flag.StringVar(&clusterName, "cluster-name", "", "optional cluster name set as the CLUSTER_NAME dashboard input")
 	flag.BoolVar(&debug, "debug", false, "turn on debug logging")
 	flag.Parse()
 
@@ -44,7 +46,19 @@ func main() {
 		log.SetLevel(log.DebugLevel)
 	}
 
-	err := run()
+	var err error
+	switch flag.Arg(0) {
+	case "":
+		err = run()
+	case constants.CommandTestAlert:
+		err = runTestAlert(context.Background(), flag.Args()[1:])
+	case constants.CommandTestRules:
+		err = runTestRules(flag.Args()[1:])
+	case constants.CommandLint:
+		err = runLint(flag.Args()[1:])
+	default:
+		err = trace.BadParameter("unknown command %q", flag.Arg(0))
+	}
 	if err != nil {
 		exitWithError(err)
 	}
@@ -58,13 +72,13 @@ func run() error {
 
 	switch mode {
 	case constants.ModeDashboards:
-		err := runDashboardsWatcher(client)
+		err := runDashboardsWatcher(client, clusterName)
 		if err != nil {
 			return trace.Wrap(err)
 		}
 
 	case constants.ModeAlerts:
-		err := runAlertsWatcher(context.Background(), client, kubeconfig)
+		err := runAlertsWatcher(context.Background(), client, kubeconfig, lintPolicy)
 		if err != nil {
 			return trace.Wrap(err)
 		}
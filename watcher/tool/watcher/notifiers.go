@@ -0,0 +1,137 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+	"github.com/gravitational/monitoring-app/watcher/lib/grafana"
+
+	"github.com/ghodss/yaml"
+	"github.com/gravitational/trace"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// syncNotifiers creates or updates Grafana notification channels or contact
+// points defined by notifier ConfigMaps and Secrets and deletes the ones created
+// by the watcher whose resources no longer exist.
+//
+// Notifiers defined by invalid resources are left as they are until the
+// resource is fixed.
+func syncNotifiers(ctx context.Context, client *grafana.Client, configMaps corev1.ConfigMapInterface, secrets corev1.SecretInterface) error {
+	sources, err := listGrafanaResources(ctx, configMaps, secrets, constants.MonitoringUpdateGrafanaNotifier)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	var errors []error
+	var notifiers []grafana.Notifier
+	// retain is the list of names of notifiers defined by invalid resources
+	var retain []string
+	names := make(map[string]struct{})
+	for _, source := range sources {
+		notifier, err := parseNotifier(ctx, source.origin.Kind, source.spec, secrets)
+		if err == nil {
+			if _, ok := names[notifier.Name]; ok {
+				err = trace.AlreadyExists("notifier %q is defined by multiple resources", notifier.Name)
+			}
+		}
+		if err != nil {
+			errors = append(errors, trace.Wrap(err, "%v %v", source.origin.Kind, source.origin.Name))
+			var resource notifierResource
+			if yaml.Unmarshal(source.spec, &resource) == nil && resource.Name != "" {
+				if _, ok := names[resource.Name]; !ok {
+					retain = append(retain, resource.Name)
+				}
+			}
+			continue
+		}
+		names[notifier.Name] = struct{}{}
+		notifiers = append(notifiers, *notifier)
+	}
+
+	if err := client.SyncNotifiers(ctx, notifiers, retain); err != nil {
+		errors = append(errors, trace.Wrap(err))
+	}
+	return trace.NewAggregate(errors...)
+}
+
+// parseNotifier parses and validates the notifier resource from the provided
+// spec of a resource of the specified kind.
+func parseNotifier(ctx context.Context, kind string, spec []byte, secrets corev1.SecretInterface) (*grafana.Notifier, error) {
+	if len(bytes.TrimSpace(spec)) == 0 {
+		return nil, trace.NotFound("empty configuration")
+	}
+
+	// The spec is not included in errors as it might contain credentials.
+	var resource notifierResource
+	if err := yaml.Unmarshal(spec, &resource); err != nil {
+		return nil, trace.Wrap(err, "failed to unmarshal notifier")
+	}
+	if resource.Name == "" {
+		return nil, trace.BadParameter("notifier name is required")
+	}
+	if resource.Spec.Type == "" {
+		return nil, trace.BadParameter("notifier %q: type is required", resource.Name)
+	}
+	secureSettings, err := secureData(ctx, secrets, kind, resource.Spec.SecureSettingsSecret, resource.Spec.SecureSettings)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return &grafana.Notifier{
+		Name:                  resource.Name,
+		Type:                  resource.Spec.Type,
+		IsDefault:             resource.Spec.IsDefault,
+		DisableResolveMessage: resource.Spec.DisableResolveMessage,
+		Settings:              resource.Spec.Settings,
+		SecureSettings:        secureSettings,
+		Matchers:              resource.Spec.Matchers,
+		Continue:              resource.Spec.Continue,
+	}, nil
+}
+
+// notifierResource defines the Grafana notifier resource
+type notifierResource struct {
+	// Metadata is the resource metadata, the name is the notifier name
+	Metadata `json:"metadata" yaml:"metadata"`
+	// Spec defines the notifier
+	Spec notifierSpec `json:"spec" yaml:"spec"`
+}
+
+// notifierSpec defines a Grafana notification channel or contact point
+type notifierSpec struct {
+	// Type is the notifier type, e.g. slack, email or webhook
+	Type string `json:"type" yaml:"type"`
+	// IsDefault is whether notifications are sent to the notifier by default
+	IsDefault bool `json:"isDefault,omitempty" yaml:"isDefault,omitempty"`
+	// DisableResolveMessage is whether to not notify when alerts are resolved
+	DisableResolveMessage bool `json:"disableResolveMessage,omitempty" yaml:"disableResolveMessage,omitempty"`
+	// Settings is the notifier type specific settings
+	Settings map[string]interface{} `json:"settings,omitempty" yaml:"settings,omitempty"`
+	// SecureSettings is the notifier type specific secure settings, only allowed in Secrets
+	SecureSettings map[string]string `json:"secureSettings,omitempty" yaml:"secureSettings,omitempty"`
+	// SecureSettingsSecret is the name of the Secret in the monitoring namespace
+	// whose keys are set as secure settings
+	SecureSettingsSecret string `json:"secureSettingsSecret,omitempty" yaml:"secureSettingsSecret,omitempty"`
+	// Matchers is the label values of alerts routed to the notifier, only used with unified alerting
+	Matchers map[string]string `json:"matchers,omitempty" yaml:"matchers,omitempty"`
+	// Continue is whether alerts routed to the notifier continue matching
+	// subsequent notification policies, only used with unified alerting
+	Continue bool `json:"continue,omitempty" yaml:"continue,omitempty"`
+}
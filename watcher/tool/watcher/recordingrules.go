@@ -0,0 +1,170 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+	"github.com/gravitational/monitoring-app/watcher/lib/kubernetes"
+	"github.com/gravitational/monitoring-app/watcher/lib/resources"
+
+	"github.com/ghodss/yaml"
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+)
+
+func createRecordingRules(client resources.Resources, update kubernetes.ConfigMapUpdate, log *log.Entry) (warnings []resourceWarning, err error) {
+	spec := []byte(update.Data[constants.ResourceSpecKey])
+	log.Debugf("Creating recording rules from spec %s.", spec)
+
+	resourceRules, err := parseRecordingRules(spec)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	owner := update.OwnerReference(kubernetes.KindConfigMap)
+	conflicts, err := client.CheckConflicts(resourceRules.CRDName, resourceRules.Groups, &owner)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	warnings = newWarnings(resourceConflictReason, "Conflicting rule definition", conflicts)
+	resourceRules.Owner = &owner
+	err = client.UpsertRecordingRules(*resourceRules)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to create recording rules")
+	}
+	return warnings, nil
+}
+
+// parseRecordingRules parses and validates the recording rules resource
+// from the provided spec.
+func parseRecordingRules(spec []byte) (*resources.RecordingRules, error) {
+	if len(bytes.TrimSpace(spec)) == 0 {
+		return nil, trace.NotFound("empty configuration")
+	}
+
+	var rules recordingRules
+	err := yaml.Unmarshal(spec, &rules)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to unmarshal %s", spec)
+	}
+
+	resourceRules, err := rules.resource()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	if err := resourceRules.Check(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return resourceRules, nil
+}
+
+func deleteRecordingRules(client resources.Resources, spec []byte, log *log.Entry) error {
+	log.Debugf("Deleting recording rules from spec %s.", spec)
+
+	var rules recordingRules
+	if err := yaml.Unmarshal(spec, &rules); err != nil {
+		return trace.Wrap(err)
+	}
+
+	return client.DeleteRecordingRules(rules.Name)
+}
+
+// recordingRules defines the recording rules resource
+type recordingRules struct {
+	Metadata `json:"metadata" yaml:"metadata"`
+	// Spec defines the recording rules
+	Spec recordingRulesSpec `json:"spec" yaml:"spec"`
+}
+
+// recordingRulesSpec defines a set of recording rules
+type recordingRulesSpec struct {
+	// Groups is the list of recording rule groups
+	Groups []recordingGroupSpec `json:"groups" yaml:"groups"`
+}
+
+// recordingGroupSpec defines a group of recording rules
+type recordingGroupSpec struct {
+	// Name is the group name
+	Name string `json:"name" yaml:"name"`
+	// Interval is an optional group evaluation interval
+	Interval duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+	// PartialResponseStrategy is an optional Thanos partial response strategy
+	PartialResponseStrategy string `json:"partial_response_strategy,omitempty" yaml:"partial_response_strategy,omitempty"`
+	// Rules is the list of recording rules
+	Rules []recordingRuleSpec `json:"rules" yaml:"rules"`
+}
+
+// recordingRuleSpec defines a recording rule
+type recordingRuleSpec struct {
+	// Record is the name of the time series to record the result to
+	Record string `json:"record" yaml:"record"`
+	// Expr is the expression to evaluate
+	Expr string `json:"expr" yaml:"expr"`
+	// Labels is the labels to add to the recorded time series
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+// resource returns the recording rules this resource describes.
+func (r recordingRules) resource() (*resources.RecordingRules, error) {
+	if r.Name == "" {
+		return nil, trace.BadParameter("recording rules name is required")
+	}
+	if len(r.Spec.Groups) == 0 {
+		return nil, trace.BadParameter("recording rules %v: at least one group is required", r.Name)
+	}
+	result := &resources.RecordingRules{CRDName: r.Name}
+	groupNames := make(map[string]struct{})
+	for i, group := range r.Spec.Groups {
+		if group.Name == "" {
+			return nil, trace.BadParameter("recording rules %v: group %v: name is required", r.Name, i)
+		}
+		if _, ok := groupNames[group.Name]; ok {
+			return nil, trace.BadParameter("recording rules %v: duplicate group %v", r.Name, group.Name)
+		}
+		groupNames[group.Name] = struct{}{}
+		if len(group.Rules) == 0 {
+			return nil, trace.BadParameter("recording rules %v: group %v: at least one rule is required", r.Name, group.Name)
+		}
+		if err := checkPartialResponseStrategy(group.PartialResponseStrategy); err != nil {
+			return nil, trace.BadParameter("recording rules %v: group %v: %v", r.Name, group.Name, err)
+		}
+		ruleGroup := resources.RuleGroup{
+			Name:                    group.Name,
+			Interval:                time.Duration(group.Interval),
+			PartialResponseStrategy: group.PartialResponseStrategy,
+		}
+		for j, rule := range group.Rules {
+			if rule.Record == "" {
+				return nil, trace.BadParameter("recording rules %v: group %v: rule %v: record is required", r.Name, group.Name, j)
+			}
+			if rule.Expr == "" {
+				return nil, trace.BadParameter("recording rules %v: group %v: rule %v: expr is required", r.Name, group.Name, rule.Record)
+			}
+			ruleGroup.Rules = append(ruleGroup.Rules, resources.Rule{
+				Record: rule.Record,
+				Expr:   rule.Expr,
+				Labels: rule.Labels,
+			})
+		}
+		result.Groups = append(result.Groups, ruleGroup)
+	}
+	return result, nil
+}
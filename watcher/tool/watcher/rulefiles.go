@@ -0,0 +1,141 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+	"github.com/gravitational/monitoring-app/watcher/lib/resources"
+
+	"github.com/ghodss/yaml"
+	"github.com/gravitational/trace"
+	"github.com/prometheus/prometheus/pkg/rulefmt"
+)
+
+// parseAlertConfigMap parses and validates the alert resource from the
+// data of the alert ConfigMap with the specified name.
+//
+// Besides the alert resource spec, the ConfigMap can contain native Prometheus
+// rule files, either under the spec key or under keys with .yaml or .yml
+// extension. Rule files are combined into a single alert named after the ConfigMap.
+func parseAlertConfigMap(name string, data map[string]string) (*resources.Alert, error) {
+	spec := data[constants.ResourceSpecKey]
+	keys := ruleFileKeys(data)
+	if len(keys) == 0 {
+		return parseAlert([]byte(spec))
+	}
+	if strings.TrimSpace(spec) != "" && !isRuleFile([]byte(spec)) {
+		return nil, trace.BadParameter("alert spec cannot be combined with rule files %v", keys)
+	}
+
+	alert := &resources.Alert{CRDName: name}
+	// groupKeys maps group names to the keys of rule files they are defined in.
+	groupKeys := make(map[string]string)
+	for _, key := range keys {
+		groups, err := parseRuleFile([]byte(data[key]))
+		if err != nil {
+			return nil, trace.BadParameter("rule file %v: %v", key, err)
+		}
+		for _, group := range groups {
+			if other, ok := groupKeys[group.Name]; ok {
+				return nil, trace.BadParameter("group %q is defined in both %v and %v", group.Name, other, key)
+			}
+			groupKeys[group.Name] = key
+		}
+		alert.Groups = append(alert.Groups, groups...)
+	}
+	if len(alert.Groups) == 0 {
+		return nil, trace.BadParameter("rule files %v do not define any groups", keys)
+	}
+
+	if err := alert.Check(); err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return alert, nil
+}
+
+// alertConfigMapName returns the name of the alert defined by the data of
+// the alert ConfigMap with the specified name.
+func alertConfigMapName(name string, data map[string]string) (string, error) {
+	if len(ruleFileKeys(data)) != 0 {
+		return name, nil
+	}
+	var alert alert
+	if err := yaml.Unmarshal([]byte(data[constants.ResourceSpecKey]), &alert); err != nil {
+		return "", trace.Wrap(err)
+	}
+	return alert.Name, nil
+}
+
+// ruleFileKeys returns the sorted list of ConfigMap keys with rule files.
+func ruleFileKeys(data map[string]string) (keys []string) {
+	for key, value := range data {
+		switch {
+		case key == constants.ResourceSpecKey:
+			if isRuleFile([]byte(value)) {
+				keys = append(keys, key)
+			}
+		case filepath.Ext(key) == ".yaml", filepath.Ext(key) == ".yml":
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isRuleFile returns true if the provided data is a Prometheus rule file
+// rather than a watcher resource spec.
+func isRuleFile(data []byte) bool {
+	var fields map[string]interface{}
+	if err := yaml.Unmarshal(data, &fields); err != nil {
+		return false
+	}
+	_, hasGroups := fields["groups"]
+	_, hasMetadata := fields["metadata"]
+	return hasGroups && !hasMetadata
+}
+
+// parseRuleFile parses and validates the Prometheus rule file.
+func parseRuleFile(data []byte) ([]resources.RuleGroup, error) {
+	ruleFile, errs := rulefmt.Parse(data)
+	if len(errs) != 0 {
+		return nil, trace.NewAggregate(errs...)
+	}
+	var groups []resources.RuleGroup
+	for _, group := range ruleFile.Groups {
+		ruleGroup := resources.RuleGroup{
+			Name:     group.Name,
+			Interval: time.Duration(group.Interval),
+		}
+		for _, rule := range group.Rules {
+			ruleGroup.Rules = append(ruleGroup.Rules, resources.Rule{
+				Record:      rule.Record.Value,
+				Alert:       rule.Alert.Value,
+				Expr:        rule.Expr.Value,
+				For:         time.Duration(rule.For),
+				Labels:      rule.Labels,
+				Annotations: rule.Annotations,
+			})
+		}
+		groups = append(groups, ruleGroup)
+	}
+	return groups, nil
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+	"github.com/gravitational/monitoring-app/watcher/lib/kubernetes"
+	"github.com/gravitational/monitoring-app/watcher/lib/resources"
+
+	"github.com/ghodss/yaml"
+	"github.com/gravitational/trace"
+	"github.com/prometheus/common/model"
+	log "github.com/sirupsen/logrus"
+)
+
+func createSLO(client resources.Resources, update kubernetes.ConfigMapUpdate, log *log.Entry) (warnings []resourceWarning, err error) {
+	spec := []byte(update.Data[constants.ResourceSpecKey])
+	log.Debugf("Creating SLO from spec %s.", spec)
+
+	_, resourceSLO, err := parseSLO(spec)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+
+	groups, err := resourceSLO.RuleGroups()
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	owner := update.OwnerReference(kubernetes.KindConfigMap)
+	conflicts, err := client.CheckConflicts(resourceSLO.CRDName, groups, &owner)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	warnings = newWarnings(resourceConflictReason, "Conflicting rule definition", conflicts)
+	resourceSLO.Owner = &owner
+	err = client.UpsertSLO(*resourceSLO)
+	if err != nil {
+		return nil, trace.Wrap(err, "failed to create SLO")
+	}
+	return warnings, nil
+}
+
+func deleteSLO(client resources.Resources, spec []byte, log *log.Entry) error {
+	log.Debugf("Deleting SLO from spec %s.", spec)
+
+	var s slo
+	if err := yaml.Unmarshal(spec, &s); err != nil {
+		return trace.Wrap(err)
+	}
+
+	return client.DeleteSLO(s.Name)
+}
+
+// parseSLO parses and validates the SLO resource from the provided spec.
+func parseSLO(spec []byte) (*slo, *resources.SLO, error) {
+	if len(bytes.TrimSpace(spec)) == 0 {
+		return nil, nil, trace.NotFound("empty configuration")
+	}
+
+	var s slo
+	err := yaml.Unmarshal(spec, &s)
+	if err != nil {
+		return nil, nil, trace.Wrap(err, "failed to unmarshal %s", spec)
+	}
+
+	resourceSLO, err := s.resource()
+	if err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+
+	if err := resourceSLO.Check(); err != nil {
+		return nil, nil, trace.Wrap(err)
+	}
+	return &s, resourceSLO, nil
+}
+
+// slo defines the service level objective resource
+type slo struct {
+	Metadata `json:"metadata" yaml:"metadata"`
+	// Spec defines the service level objective
+	Spec sloSpec `json:"spec" yaml:"spec"`
+}
+
+// sloSpec defines a service level objective
+type sloSpec struct {
+	// SLI defines the service level indicator
+	SLI sliSpec `json:"sli" yaml:"sli"`
+	// Objective is the percentage of good events, e.g. 99.9
+	Objective float64 `json:"objective" yaml:"objective"`
+	// Window is the period the objective is defined for, 30 days by default
+	Window duration `json:"window,omitempty" yaml:"window,omitempty"`
+	// Alerting defines the generated burn rate alerts
+	Alerting sloAlertingSpec `json:"alerting,omitempty" yaml:"alerting,omitempty"`
+	// Dashboard is whether to create a Grafana dashboard for the objective
+	Dashboard bool `json:"dashboard,omitempty" yaml:"dashboard,omitempty"`
+}
+
+// sliSpec defines a service level indicator as the ratio of good events
+//
+// Both expressions must use the {{.window}} placeholder as the range of
+// range vectors, e.g. sum(rate(http_requests_total[{{.window}}])).
+type sliSpec struct {
+	// Good is the expression that returns the rate of good events
+	Good string `json:"good" yaml:"good"`
+	// Total is the expression that returns the rate of all events
+	Total string `json:"total" yaml:"total"`
+}
+
+// sloAlertingSpec defines the burn rate alerts of a service level objective
+type sloAlertingSpec struct {
+	// PageSeverity is the severity of alerts for fast error budget burn
+	PageSeverity string `json:"pageSeverity,omitempty" yaml:"pageSeverity,omitempty"`
+	// TicketSeverity is the severity of alerts for slow error budget burn
+	TicketSeverity string `json:"ticketSeverity,omitempty" yaml:"ticketSeverity,omitempty"`
+	// Labels is the additional alert labels
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	// Annotations is the additional alert annotations
+	Annotations map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+}
+
+// resource returns the service level objective this resource describes.
+func (s slo) resource() (*resources.SLO, error) {
+	if s.Name == "" {
+		return nil, trace.BadParameter("SLO name is required")
+	}
+	if s.Spec.SLI.Good == "" || s.Spec.SLI.Total == "" {
+		return nil, trace.BadParameter("SLO %v: both good and total SLI expressions are required", s.Name)
+	}
+	result := &resources.SLO{
+		CRDName:        s.Name,
+		Good:           s.Spec.SLI.Good,
+		Total:          s.Spec.SLI.Total,
+		Objective:      s.Spec.Objective,
+		Window:         time.Duration(s.Spec.Window),
+		PageSeverity:   s.Spec.Alerting.PageSeverity,
+		TicketSeverity: s.Spec.Alerting.TicketSeverity,
+		Labels:         s.Spec.Alerting.Labels,
+		Annotations:    s.Spec.Alerting.Annotations,
+	}
+	if result.Window == 0 {
+		result.Window = defaultSLOWindow
+	}
+	if result.PageSeverity == "" {
+		result.PageSeverity = defaultPageSeverity
+	}
+	if result.TicketSeverity == "" {
+		result.TicketSeverity = defaultTicketSeverity
+	}
+	return result, nil
+}
+
+// sloDashboard returns the Grafana dashboard for the service level objective.
+func sloDashboard(s resources.SLO) (string, error) {
+	selector := fmt.Sprintf("{%v=%q}", constants.SLOLabel, s.CRDName)
+	period := resources.SLOErrorRatioRecord(s.Window) + selector
+	target := func(refID, expr, legend string) map[string]interface{} {
+		return map[string]interface{}{
+			"expr":         expr,
+			"legendFormat": legend,
+			"refId":        refID,
+		}
+	}
+	stat := func(id int, title, expr string, x int) map[string]interface{} {
+		return map[string]interface{}{
+			"id":         id,
+			"type":       "stat",
+			"title":      title,
+			"datasource": "Prometheus",
+			"gridPos":    map[string]int{"x": x, "y": 0, "w": 8, "h": 6},
+			"targets":    []interface{}{target("A", expr, "")},
+			"fieldConfig": map[string]interface{}{
+				"defaults": map[string]interface{}{"unit": "percentunit", "decimals": 3},
+			},
+		}
+	}
+	var burnRateTargets []interface{}
+	for i, window := range []time.Duration{5 * time.Minute, time.Hour, 6 * time.Hour, 24 * time.Hour} {
+		burnRateTargets = append(burnRateTargets, target(string(rune('A'+i)),
+			fmt.Sprintf("%v%v / on(%v) slo:error_budget:ratio%v",
+				resources.SLOErrorRatioRecord(window), selector, constants.SLOLabel, selector),
+			model.Duration(window).String()))
+	}
+	dashboard := map[string]interface{}{
+		"title":         fmt.Sprintf("SLO / %v", s.CRDName),
+		"tags":          []string{"slo"},
+		"editable":      false,
+		"schemaVersion": 27,
+		"time":          map[string]string{"from": "now-" + model.Duration(s.Window).String(), "to": "now"},
+		"panels": []interface{}{
+			stat(1, fmt.Sprintf("SLI over %v (objective %v%%)", model.Duration(s.Window), s.Objective),
+				"1 - "+period, 0),
+			stat(2, "Error budget remaining", "slo:period_error_budget_remaining:ratio"+selector, 8),
+			stat(3, "SLI over 1h", "1 - "+resources.SLOErrorRatioRecord(time.Hour)+selector, 16),
+			map[string]interface{}{
+				"id":         4,
+				"type":       "graph",
+				"title":      "Error budget burn rate",
+				"datasource": "Prometheus",
+				"gridPos":    map[string]int{"x": 0, "y": 6, "w": 12, "h": 9},
+				"targets":    burnRateTargets,
+			},
+			map[string]interface{}{
+				"id":         5,
+				"type":       "graph",
+				"title":      "Error budget remaining",
+				"datasource": "Prometheus",
+				"gridPos":    map[string]int{"x": 12, "y": 6, "w": 12, "h": 9},
+				"targets":    []interface{}{target("A", "slo:period_error_budget_remaining:ratio"+selector, "remaining")},
+				"yaxes": []interface{}{
+					map[string]interface{}{"format": "percentunit", "show": true},
+					map[string]interface{}{"format": "short", "show": false},
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(dashboard)
+	if err != nil {
+		return "", trace.Wrap(err)
+	}
+	return string(data), nil
+}
+
+const (
+	// defaultSLOWindow is the default service level objective period.
+	defaultSLOWindow = 30 * 24 * time.Hour
+	// defaultPageSeverity is the default severity of fast burn alerts.
+	defaultPageSeverity = "critical"
+	// defaultTicketSeverity is the default severity of slow burn alerts.
+	defaultTicketSeverity = "warning"
+)
@@ -0,0 +1,196 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/alertmanager"
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+	"github.com/gravitational/monitoring-app/watcher/lib/kubernetes"
+	"github.com/gravitational/monitoring-app/watcher/lib/resources"
+
+	"github.com/gravitational/rigging"
+	"github.com/gravitational/trace"
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// runTestAlert sends a synthetic test alert through Alertmanager and waits
+// until the notification for it has been sent.
+func runTestAlert(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet(constants.CommandTestAlert, flag.ExitOnError)
+	addr := flags.String("alertmanager-addr", "", "Alertmanager API address, defaults to the in-cluster Alertmanager service")
+	timeout := flags.Duration("timeout", 0, "maximum amount of time to wait for the notification")
+	labels := labelsFlag{}
+	flags.Var(labels, "label", "additional test alert label in key=value format, can be repeated")
+	if err := flags.Parse(args); err != nil {
+		return trace.Wrap(err)
+	}
+
+	client, err := alertmanager.NewClient(*addr)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	kubernetesClient, err := kubernetes.NewClient(kubeconfig)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	monitoringClient, err := kubernetes.NewMonitoringClient(kubeconfig)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	rClient, err := resources.New(ctx, resources.ClientConfig{
+		KubernetesClient: kubernetesClient.Clientset,
+		MonitoringClient: monitoringClient,
+		Namespace:        constants.MonitoringNamespace,
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+
+	config, err := testAlertConfig(rClient, labels)
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	config.Timeout = *timeout
+	if err := client.SendTestAlert(ctx, *config); err != nil {
+		return trace.Wrap(err)
+	}
+
+	fmt.Println("Test alert notification has been sent.")
+	return nil
+}
+
+// testAlertConfig returns the configuration of a new test alert with the
+// provided additional labels routed by the Alertmanager configuration.
+//
+// Labels the matched routes group alerts by are set to the unique test id so
+// the test alert forms its own group and is sent after the group wait.
+func testAlertConfig(client resources.Resources, labels map[string]string) (*alertmanager.TestAlertConfig, error) {
+	labels = alertmanager.TestAlertLabels(labels)
+	routes, err := client.RouteAlert(labels)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	for _, route := range routes {
+		for _, name := range route.GroupBy {
+			if _, ok := labels[name]; !ok && name != groupByAll {
+				labels[name] = labels[alertmanager.TestAlertIDLabel]
+			}
+		}
+	}
+	// The grouping labels might change the matching routes.
+	routes, err = client.RouteAlert(labels)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	config := &alertmanager.TestAlertConfig{Labels: labels}
+	seen := make(map[string]struct{})
+	for _, route := range routes {
+		if _, ok := seen[route.Receiver]; ok || len(route.Integrations) == 0 {
+			continue
+		}
+		seen[route.Receiver] = struct{}{}
+		config.Receivers = append(config.Receivers, alertmanager.TestAlertReceiver{
+			Name:         route.Receiver,
+			Integrations: route.Integrations,
+		})
+	}
+	return config, nil
+}
+
+// sendTestAlert sends a synthetic test alert through the Alertmanager routes
+// and records the result as an event on the alert target resource.
+func sendTestAlert(ctx context.Context, client *alertmanager.Client, rClient resources.Resources,
+	events corev1.EventInterface, update kubernetes.ConfigMapUpdate, log *log.Entry) {
+	eventType, reason, message := v1.EventTypeNormal, testAlertSucceededReason,
+		"Test alert notification has been sent."
+	config, err := testAlertConfig(rClient, nil)
+	if err == nil {
+		err = client.SendTestAlert(ctx, *config)
+	}
+	if err != nil {
+		log.WithError(err).Warn("Failed to send test alert.")
+		eventType, reason, message = v1.EventTypeWarning, testAlertFailedReason,
+			fmt.Sprintf("Failed to send test alert: %v.", err)
+	}
+	err = kubernetes.RecordEvent(ctx, events, update.ObjectReference(kubernetes.KindConfigMap),
+		eventType, reason, message)
+	if err != nil {
+		log.WithError(err).Warn("Failed to record test alert event.")
+	}
+}
+
+// clearTestAlertAnnotation removes the test alert annotation from the
+// specified alert target ConfigMap.
+func clearTestAlertAnnotation(ctx context.Context, configMaps corev1.ConfigMapInterface, name string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				constants.TestAlertAnnotation: nil,
+			},
+		},
+	})
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	_, err = configMaps.Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return trace.Wrap(rigging.ConvertError(err))
+	}
+	return nil
+}
+
+// labelsFlag is a command line flag that collects key=value label pairs.
+type labelsFlag map[string]string
+
+// String returns the labels as a comma-separated list.
+func (l labelsFlag) String() string {
+	var pairs []string
+	for k, v := range l {
+		pairs = append(pairs, fmt.Sprintf("%v=%v", k, v))
+	}
+	return strings.Join(pairs, ",")
+}
+
+// Set parses a key=value label pair.
+func (l labelsFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return trace.BadParameter("expected key=value, got %q", value)
+	}
+	l[parts[0]] = parts[1]
+	return nil
+}
+
+const (
+	// testAlertSucceededReason is the event reason for a delivered test alert.
+	testAlertSucceededReason = "TestAlertSucceeded"
+	// testAlertFailedReason is the event reason for a failed test alert.
+	testAlertFailedReason = "TestAlertFailed"
+	// groupByAll is the route grouping label that groups alerts by all labels.
+	groupByAll = "..."
+)
@@ -0,0 +1,172 @@
+/*
+Copyright 2021 Gravitational, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gravitational/monitoring-app/watcher/lib/alertpacks"
+	"github.com/gravitational/monitoring-app/watcher/lib/constants"
+	"github.com/gravitational/monitoring-app/watcher/lib/kubernetes"
+	"github.com/gravitational/monitoring-app/watcher/lib/resources"
+	"github.com/gravitational/monitoring-app/watcher/lib/rulestest"
+
+	"github.com/gravitational/trace"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// runTestRules runs offline unit tests for alert and recording rule ConfigMaps.
+//
+// Test files use the promtool rule unit test format with rule_files
+// referring to ConfigMap manifests instead of Prometheus rule files.
+func runTestRules(args []string) error {
+	flags := flag.NewFlagSet(constants.CommandTestRules, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: watcher %v test-file [test-file...]\n", constants.CommandTestRules)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return trace.Wrap(err)
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return trace.BadParameter("at least one test file is required")
+	}
+
+	return trace.Wrap(rulestest.Run(rulestest.Config{
+		RuleFiles: manifestRuleFiles,
+	}, flags.Args()...))
+}
+
+// manifestRuleFiles returns the Prometheus rule files generated for the alert,
+// recording rule, SLO and alert pack ConfigMaps in the specified manifest file, one per ConfigMap.
+func manifestRuleFiles(filename string) ([][]byte, error) {
+	configMaps, err := manifestConfigMaps(filename)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	var ruleFiles [][]byte
+	for _, configMap := range configMaps {
+		data, err := configMapRuleFile(configMap)
+		if err != nil {
+			return nil, trace.Wrap(err, "ConfigMap %v", configMap.Name)
+		}
+		if data != nil {
+			ruleFiles = append(ruleFiles, data)
+		}
+	}
+	if len(ruleFiles) == 0 {
+		return nil, trace.NotFound("no alert, recording rule, SLO or alert pack ConfigMaps found")
+	}
+	return ruleFiles, nil
+}
+
+// manifestConfigMaps returns the ConfigMaps in the specified manifest file.
+func manifestConfigMaps(filename string) ([]v1.ConfigMap, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, trace.ConvertSystemError(err)
+	}
+	defer f.Close()
+
+	var configMaps []v1.ConfigMap
+	decoder := yaml.NewYAMLOrJSONDecoder(f, manifestBufferSize)
+	for {
+		var manifest configMapManifest
+		err := decoder.Decode(&manifest)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		for _, configMap := range append([]v1.ConfigMap{manifest.ConfigMap}, manifest.Items...) {
+			if configMap.Kind == kubernetes.KindConfigMap {
+				configMaps = append(configMaps, configMap)
+			}
+		}
+	}
+	return configMaps, nil
+}
+
+// configMapRuleFile returns the Prometheus rule file generated for the
+// provided ConfigMap or nil if it does not define any rules.
+func configMapRuleFile(configMap v1.ConfigMap) ([]byte, error) {
+	var groups []resources.RuleGroup
+	switch configMap.Labels[constants.MonitoringLabel] {
+	case constants.MonitoringUpdateAlert:
+		alert, err := parseAlertConfigMap(configMap.Name, configMap.Data)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		groups = alert.RuleGroups()
+	case constants.MonitoringUpdateRecordingRule:
+		rules, err := parseRecordingRules([]byte(configMap.Data[constants.ResourceSpecKey]))
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		groups = rules.Groups
+	case constants.MonitoringUpdateAlertPack:
+		name, config, err := parseAlertPack([]byte(configMap.Data[constants.ResourceSpecKey]))
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		pack, err := alertpacks.Get(name)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		alert, err := pack.Alert(*config)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if alert == nil {
+			return nil, nil
+		}
+		groups = alert.RuleGroups()
+	case constants.MonitoringUpdateSLO:
+		_, slo, err := parseSLO([]byte(configMap.Data[constants.ResourceSpecKey]))
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		if groups, err = slo.RuleGroups(); err != nil {
+			return nil, trace.Wrap(err)
+		}
+	default:
+		return nil, nil
+	}
+	data, err := resources.RuleFile(groups)
+	if err != nil {
+		return nil, trace.Wrap(err)
+	}
+	return data, nil
+}
+
+// configMapManifest is a manifest with either a single ConfigMap or a list of them.
+type configMapManifest struct {
+	v1.ConfigMap
+	// Items is the list of ConfigMaps if the manifest is a list.
+	Items []v1.ConfigMap `json:"items"`
+}
+
+const (
+	// manifestBufferSize is the buffer size used to detect manifest format.
+	manifestBufferSize = 4096
+)